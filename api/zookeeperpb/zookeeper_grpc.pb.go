@@ -0,0 +1,641 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: zookeeper.proto
+
+package zookeeperpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Zookeeper_Push_FullMethodName               = "/zookeeper.v1.Zookeeper/Push"
+	Zookeeper_PushBatch_FullMethodName          = "/zookeeper.v1.Zookeeper/PushBatch"
+	Zookeeper_PushTransaction_FullMethodName    = "/zookeeper.v1.Zookeeper/PushTransaction"
+	Zookeeper_Produce_FullMethodName            = "/zookeeper.v1.Zookeeper/Produce"
+	Zookeeper_Pop_FullMethodName                = "/zookeeper.v1.Zookeeper/Pop"
+	Zookeeper_Consume_FullMethodName            = "/zookeeper.v1.Zookeeper/Consume"
+	Zookeeper_Ack_FullMethodName                = "/zookeeper.v1.Zookeeper/Ack"
+	Zookeeper_Nack_FullMethodName               = "/zookeeper.v1.Zookeeper/Nack"
+	Zookeeper_GetKeySettings_FullMethodName     = "/zookeeper.v1.Zookeeper/GetKeySettings"
+	Zookeeper_SetKeySettings_FullMethodName     = "/zookeeper.v1.Zookeeper/SetKeySettings"
+	Zookeeper_ListDeadLetters_FullMethodName    = "/zookeeper.v1.Zookeeper/ListDeadLetters"
+	Zookeeper_RedriveDeadLetters_FullMethodName = "/zookeeper.v1.Zookeeper/RedriveDeadLetters"
+	Zookeeper_PurgeDeadLetters_FullMethodName   = "/zookeeper.v1.Zookeeper/PurgeDeadLetters"
+)
+
+// ZookeeperClient is the client API for Zookeeper service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ZookeeperClient interface {
+	// Push pushes a message to its key.
+	Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*PushResult, error)
+	// PushBatch pushes several messages and returns a result for each of them.
+	PushBatch(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushBatchResponse, error)
+	// PushTransaction pushes several messages as one unit: either every message is pushed or none is.
+	PushTransaction(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushTransactionResponse, error)
+	// Produce pushes every message sent on the stream and returns their results once the stream is closed.
+	Produce(ctx context.Context, opts ...grpc.CallOption) (Zookeeper_ProduceClient, error)
+	// Pop leases a message of a key, or of any key if the key is empty.
+	Pop(ctx context.Context, in *PopRequest, opts ...grpc.CallOption) (*PopResponse, error)
+	// Consume streams leased messages of the keys, or of any key if none is given.
+	Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (Zookeeper_ConsumeClient, error)
+	// Ack acknowledges a leased message.
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error)
+	// Nack releases a leased message for redelivery.
+	Nack(ctx context.Context, in *NackRequest, opts ...grpc.CallOption) (*NackResponse, error)
+	// GetKeySettings returns the retention settings and the size of a key.
+	GetKeySettings(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeySettingsResponse, error)
+	// SetKeySettings sets the retention settings of a key.
+	SetKeySettings(ctx context.Context, in *SetKeySettingsRequest, opts ...grpc.CallOption) (*KeySettingsResponse, error)
+	// ListDeadLetters lists the messages in the dead-letter key of a key.
+	ListDeadLetters(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*DeadLettersResponse, error)
+	// RedriveDeadLetters moves the messages in the dead-letter key of a key back to the key.
+	RedriveDeadLetters(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*CountResponse, error)
+	// PurgeDeadLetters removes the messages in the dead-letter key of a key.
+	PurgeDeadLetters(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*CountResponse, error)
+}
+
+type zookeeperClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewZookeeperClient(cc grpc.ClientConnInterface) ZookeeperClient {
+	return &zookeeperClient{cc}
+}
+
+func (c *zookeeperClient) Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*PushResult, error) {
+	out := new(PushResult)
+	err := c.cc.Invoke(ctx, Zookeeper_Push_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) PushBatch(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushBatchResponse, error) {
+	out := new(PushBatchResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_PushBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) PushTransaction(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushTransactionResponse, error) {
+	out := new(PushTransactionResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_PushTransaction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) Produce(ctx context.Context, opts ...grpc.CallOption) (Zookeeper_ProduceClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Zookeeper_ServiceDesc.Streams[0], Zookeeper_Produce_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &zookeeperProduceClient{stream}
+	return x, nil
+}
+
+type Zookeeper_ProduceClient interface {
+	Send(*PushRequest) error
+	CloseAndRecv() (*PushBatchResponse, error)
+	grpc.ClientStream
+}
+
+type zookeeperProduceClient struct {
+	grpc.ClientStream
+}
+
+func (x *zookeeperProduceClient) Send(m *PushRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *zookeeperProduceClient) CloseAndRecv() (*PushBatchResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PushBatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *zookeeperClient) Pop(ctx context.Context, in *PopRequest, opts ...grpc.CallOption) (*PopResponse, error) {
+	out := new(PopResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_Pop_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (Zookeeper_ConsumeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Zookeeper_ServiceDesc.Streams[1], Zookeeper_Consume_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &zookeeperConsumeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Zookeeper_ConsumeClient interface {
+	Recv() (*Delivery, error)
+	grpc.ClientStream
+}
+
+type zookeeperConsumeClient struct {
+	grpc.ClientStream
+}
+
+func (x *zookeeperConsumeClient) Recv() (*Delivery, error) {
+	m := new(Delivery)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *zookeeperClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error) {
+	out := new(AckResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_Ack_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) Nack(ctx context.Context, in *NackRequest, opts ...grpc.CallOption) (*NackResponse, error) {
+	out := new(NackResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_Nack_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) GetKeySettings(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeySettingsResponse, error) {
+	out := new(KeySettingsResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_GetKeySettings_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) SetKeySettings(ctx context.Context, in *SetKeySettingsRequest, opts ...grpc.CallOption) (*KeySettingsResponse, error) {
+	out := new(KeySettingsResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_SetKeySettings_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) ListDeadLetters(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*DeadLettersResponse, error) {
+	out := new(DeadLettersResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_ListDeadLetters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) RedriveDeadLetters(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*CountResponse, error) {
+	out := new(CountResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_RedriveDeadLetters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zookeeperClient) PurgeDeadLetters(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*CountResponse, error) {
+	out := new(CountResponse)
+	err := c.cc.Invoke(ctx, Zookeeper_PurgeDeadLetters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ZookeeperServer is the server API for Zookeeper service.
+// All implementations must embed UnimplementedZookeeperServer
+// for forward compatibility
+type ZookeeperServer interface {
+	// Push pushes a message to its key.
+	Push(context.Context, *PushRequest) (*PushResult, error)
+	// PushBatch pushes several messages and returns a result for each of them.
+	PushBatch(context.Context, *PushBatchRequest) (*PushBatchResponse, error)
+	// PushTransaction pushes several messages as one unit: either every message is pushed or none is.
+	PushTransaction(context.Context, *PushBatchRequest) (*PushTransactionResponse, error)
+	// Produce pushes every message sent on the stream and returns their results once the stream is closed.
+	Produce(Zookeeper_ProduceServer) error
+	// Pop leases a message of a key, or of any key if the key is empty.
+	Pop(context.Context, *PopRequest) (*PopResponse, error)
+	// Consume streams leased messages of the keys, or of any key if none is given.
+	Consume(*ConsumeRequest, Zookeeper_ConsumeServer) error
+	// Ack acknowledges a leased message.
+	Ack(context.Context, *AckRequest) (*AckResponse, error)
+	// Nack releases a leased message for redelivery.
+	Nack(context.Context, *NackRequest) (*NackResponse, error)
+	// GetKeySettings returns the retention settings and the size of a key.
+	GetKeySettings(context.Context, *KeyRequest) (*KeySettingsResponse, error)
+	// SetKeySettings sets the retention settings of a key.
+	SetKeySettings(context.Context, *SetKeySettingsRequest) (*KeySettingsResponse, error)
+	// ListDeadLetters lists the messages in the dead-letter key of a key.
+	ListDeadLetters(context.Context, *KeyRequest) (*DeadLettersResponse, error)
+	// RedriveDeadLetters moves the messages in the dead-letter key of a key back to the key.
+	RedriveDeadLetters(context.Context, *KeyRequest) (*CountResponse, error)
+	// PurgeDeadLetters removes the messages in the dead-letter key of a key.
+	PurgeDeadLetters(context.Context, *KeyRequest) (*CountResponse, error)
+	mustEmbedUnimplementedZookeeperServer()
+}
+
+// UnimplementedZookeeperServer must be embedded to have forward compatible implementations.
+type UnimplementedZookeeperServer struct {
+}
+
+func (UnimplementedZookeeperServer) Push(context.Context, *PushRequest) (*PushResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+func (UnimplementedZookeeperServer) PushBatch(context.Context, *PushBatchRequest) (*PushBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushBatch not implemented")
+}
+func (UnimplementedZookeeperServer) PushTransaction(context.Context, *PushBatchRequest) (*PushTransactionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushTransaction not implemented")
+}
+func (UnimplementedZookeeperServer) Produce(Zookeeper_ProduceServer) error {
+	return status.Errorf(codes.Unimplemented, "method Produce not implemented")
+}
+func (UnimplementedZookeeperServer) Pop(context.Context, *PopRequest) (*PopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pop not implemented")
+}
+func (UnimplementedZookeeperServer) Consume(*ConsumeRequest, Zookeeper_ConsumeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Consume not implemented")
+}
+func (UnimplementedZookeeperServer) Ack(context.Context, *AckRequest) (*AckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ack not implemented")
+}
+func (UnimplementedZookeeperServer) Nack(context.Context, *NackRequest) (*NackResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Nack not implemented")
+}
+func (UnimplementedZookeeperServer) GetKeySettings(context.Context, *KeyRequest) (*KeySettingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetKeySettings not implemented")
+}
+func (UnimplementedZookeeperServer) SetKeySettings(context.Context, *SetKeySettingsRequest) (*KeySettingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetKeySettings not implemented")
+}
+func (UnimplementedZookeeperServer) ListDeadLetters(context.Context, *KeyRequest) (*DeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeadLetters not implemented")
+}
+func (UnimplementedZookeeperServer) RedriveDeadLetters(context.Context, *KeyRequest) (*CountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RedriveDeadLetters not implemented")
+}
+func (UnimplementedZookeeperServer) PurgeDeadLetters(context.Context, *KeyRequest) (*CountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeDeadLetters not implemented")
+}
+func (UnimplementedZookeeperServer) mustEmbedUnimplementedZookeeperServer() {}
+
+// UnsafeZookeeperServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ZookeeperServer will
+// result in compilation errors.
+type UnsafeZookeeperServer interface {
+	mustEmbedUnimplementedZookeeperServer()
+}
+
+func RegisterZookeeperServer(s grpc.ServiceRegistrar, srv ZookeeperServer) {
+	s.RegisterService(&Zookeeper_ServiceDesc, srv)
+}
+
+func _Zookeeper_Push_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).Push(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_Push_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).Push(ctx, req.(*PushRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_PushBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).PushBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_PushBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).PushBatch(ctx, req.(*PushBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_PushTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).PushTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_PushTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).PushTransaction(ctx, req.(*PushBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_Produce_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ZookeeperServer).Produce(&zookeeperProduceServer{stream})
+}
+
+type Zookeeper_ProduceServer interface {
+	SendAndClose(*PushBatchResponse) error
+	Recv() (*PushRequest, error)
+	grpc.ServerStream
+}
+
+type zookeeperProduceServer struct {
+	grpc.ServerStream
+}
+
+func (x *zookeeperProduceServer) SendAndClose(m *PushBatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *zookeeperProduceServer) Recv() (*PushRequest, error) {
+	m := new(PushRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Zookeeper_Pop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).Pop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_Pop_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).Pop(ctx, req.(*PopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_Consume_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ConsumeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ZookeeperServer).Consume(m, &zookeeperConsumeServer{stream})
+}
+
+type Zookeeper_ConsumeServer interface {
+	Send(*Delivery) error
+	grpc.ServerStream
+}
+
+type zookeeperConsumeServer struct {
+	grpc.ServerStream
+}
+
+func (x *zookeeperConsumeServer) Send(m *Delivery) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Zookeeper_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_Ack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_Nack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).Nack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_Nack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).Nack(ctx, req.(*NackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_GetKeySettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).GetKeySettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_GetKeySettings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).GetKeySettings(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_SetKeySettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetKeySettingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).SetKeySettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_SetKeySettings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).SetKeySettings(ctx, req.(*SetKeySettingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_ListDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).ListDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_ListDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).ListDeadLetters(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_RedriveDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).RedriveDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_RedriveDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).RedriveDeadLetters(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zookeeper_PurgeDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZookeeperServer).PurgeDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zookeeper_PurgeDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZookeeperServer).PurgeDeadLetters(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Zookeeper_ServiceDesc is the grpc.ServiceDesc for Zookeeper service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Zookeeper_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "zookeeper.v1.Zookeeper",
+	HandlerType: (*ZookeeperServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Push",
+			Handler:    _Zookeeper_Push_Handler,
+		},
+		{
+			MethodName: "PushBatch",
+			Handler:    _Zookeeper_PushBatch_Handler,
+		},
+		{
+			MethodName: "PushTransaction",
+			Handler:    _Zookeeper_PushTransaction_Handler,
+		},
+		{
+			MethodName: "Pop",
+			Handler:    _Zookeeper_Pop_Handler,
+		},
+		{
+			MethodName: "Ack",
+			Handler:    _Zookeeper_Ack_Handler,
+		},
+		{
+			MethodName: "Nack",
+			Handler:    _Zookeeper_Nack_Handler,
+		},
+		{
+			MethodName: "GetKeySettings",
+			Handler:    _Zookeeper_GetKeySettings_Handler,
+		},
+		{
+			MethodName: "SetKeySettings",
+			Handler:    _Zookeeper_SetKeySettings_Handler,
+		},
+		{
+			MethodName: "ListDeadLetters",
+			Handler:    _Zookeeper_ListDeadLetters_Handler,
+		},
+		{
+			MethodName: "RedriveDeadLetters",
+			Handler:    _Zookeeper_RedriveDeadLetters_Handler,
+		},
+		{
+			MethodName: "PurgeDeadLetters",
+			Handler:    _Zookeeper_PurgeDeadLetters_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Produce",
+			Handler:       _Zookeeper_Produce_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Consume",
+			Handler:       _Zookeeper_Consume_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "zookeeper.proto",
+}
@@ -0,0 +1,408 @@
+// Command zkctl pushes and pops messages and administers a zookeeper cluster.
+package main
+
+import (
+	"Zookeeper/pkg/client"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage: zkctl [-addr address] [-json] <command> [arguments]
+
+Commands:
+  push -key key [-ttl d] [-delay d] [-priority n] [-lines] [file ...]
+        push stdin or each file as a raw message, or each line with -lines
+  pop [-key key | -pattern pattern] [-wait d] [-no-ack]
+        pop a message and write its raw value
+  tail [-key key ...] [-prefetch n] [-ack]
+        print the messages of keys or patterns as they arrive until interrupted
+  keys [pattern]
+        list keys with their master and replica brokers
+  brokers
+        list brokers with their health and latency
+  distribution
+        compare the keys and queued bytes of brokers with their weighted target
+  move -key key -from broker -to broker
+        move the copy of a key held by a broker to another broker
+  replicate -key key -factor n
+        set the number of brokers holding a copy of a key
+  drain broker
+        move every key off a broker and stop assigning keys to it
+  undrain broker
+        let keys be assigned to a drained broker again
+
+The address defaults to $ZOOKEEPER_ADDR or http://localhost:8000.
+`
+
+// batchSize is the number of lines pushed per request with push -lines
+const batchSize = 100
+
+type keysFlag []string
+
+func (k *keysFlag) String() string {
+	return strings.Join(*k, ",")
+}
+
+func (k *keysFlag) Set(v string) error {
+	*k = append(*k, v)
+	return nil
+}
+
+func main() {
+	addr := os.Getenv("ZOOKEEPER_ADDR")
+	if addr == "" {
+		addr = "http://localhost:8000"
+	}
+	flag.StringVar(&addr, "addr", addr, "zookeeper address")
+	asJSON := flag.Bool("json", false, "print JSON instead of tables")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cli := &cli{client: client.New(addr), json: *asJSON, out: os.Stdout}
+	commands := map[string]func(context.Context, []string) error{
+		"push":         cli.push,
+		"pop":          cli.pop,
+		"tail":         cli.tail,
+		"keys":         cli.keys,
+		"brokers":      cli.brokers,
+		"distribution": cli.distribution,
+		"move":         cli.move,
+		"replicate":    cli.replicate,
+		"drain":        cli.drain,
+		"undrain":      cli.undrain,
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "zkctl: unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := cmd(ctx, flag.Args()[1:]); err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "zkctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+type cli struct {
+	client *client.Client
+	json   bool
+	out    io.Writer
+}
+
+func (c *cli) push(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	key := fs.String("key", "", "key to push to")
+	ttl := fs.Duration("ttl", 0, "time to live of the messages")
+	delay := fs.Duration("delay", 0, "delay before the messages are delivered")
+	priority := fs.Int("priority", 0, fmt.Sprintf("priority of the messages, 0 to %d", client.MaxPriority))
+	lines := fs.Bool("lines", false, "push each line as a message")
+	fs.Parse(args)
+	if *key == "" {
+		return errors.New("push: -key is required")
+	}
+
+	newRequest := func(value []byte) client.PushRequest {
+		return client.PushRequest{Key: *key, Value: value, TTL: *ttl, Delay: *delay, Priority: *priority}
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		r, err := open(name)
+		if err != nil {
+			return err
+		}
+
+		if !*lines {
+			req := newRequest(nil)
+			res, err := c.client.PushRaw(ctx, &req, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			if err := c.printResults([]client.PushResult{*res}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var batch []client.PushRequest
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			results, err := c.client.PushBatch(ctx, batch)
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
+			return c.printResults(results)
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			batch = append(batch, newRequest([]byte(scanner.Text())))
+			if len(batch) == batchSize {
+				if err := flush(); err != nil {
+					r.Close()
+					return err
+				}
+			}
+		}
+		r.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) pop(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("pop", flag.ExitOnError)
+	key := fs.String("key", "", "key to pop from, any key if empty")
+	pattern := fs.String("pattern", "", "glob pattern of the keys to pop from, e.g. orders.*")
+	wait := fs.Duration("wait", 0, "how long to wait for a message")
+	noAck := fs.Bool("no-ack", false, "leave the message leased instead of acknowledging it")
+	fs.Parse(args)
+
+	opts := client.PopOptions{Key: *key, Pattern: *pattern, Wait: *wait}
+	var id string
+	if c.json {
+		d, err := c.client.Pop(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			return errors.New("queue is empty")
+		}
+		if err := c.printDelivery(d); err != nil {
+			return err
+		}
+		id = d.ID
+	} else {
+		// The value is copied as is, so binary values and large blobs can be popped
+		d, err := c.client.PopRaw(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			return errors.New("queue is empty")
+		}
+		_, err = io.Copy(c.out, d.Body)
+		d.Body.Close()
+		if err != nil {
+			return err
+		}
+		id = d.ID
+	}
+	if *noAck {
+		return nil
+	}
+	return c.client.Ack(ctx, id)
+}
+
+func (c *cli) tail(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	var keys keysFlag
+	fs.Var(&keys, "key", "key or glob pattern to tail, may be repeated; any key if not set")
+	prefetch := fs.Int("prefetch", 0, "number of unacknowledged messages")
+	ack := fs.Bool("ack", false, "acknowledge printed messages, consuming them; otherwise they stay leased and are redelivered once their lease expires")
+	fs.Parse(args)
+
+	opts := client.SubscribeOptions{Keys: keys, Prefetch: *prefetch}
+	return c.client.Subscribe(ctx, opts, func(d *client.Delivery) error {
+		if err := c.printDelivery(d); err != nil {
+			return err
+		}
+		if !*ack {
+			return nil
+		}
+		return c.client.Ack(ctx, d.ID)
+	})
+}
+
+func (c *cli) keys(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("keys: expected at most one pattern")
+	}
+	pattern := ""
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	keys, err := c.client.ListKeys(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(keys)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tMASTER\tREPLICAS")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", k.Key, k.Master, strings.Join(k.Replicas, ","))
+	}
+	return w.Flush()
+}
+
+func (c *cli) brokers(ctx context.Context, args []string) error {
+	brokers, err := c.client.ListBrokers(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(brokers)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tSTATUS\tLATENCY\tLABELS\tKEYS\tMASTER FOR")
+	for _, b := range brokers {
+		status := "down"
+		if b.Healthy {
+			status = "up"
+		}
+		if b.Draining {
+			status += ",draining"
+		}
+		var labels []string
+		for name, value := range b.Labels {
+			labels = append(labels, name+"="+value)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n", b.Name, b.Address, status, b.Latency, strings.Join(labels, ","), b.Keys, b.MasterFor)
+	}
+	return w.Flush()
+}
+
+func (c *cli) distribution(ctx context.Context, args []string) error {
+	brokers, err := c.client.Distribution(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(brokers)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tWEIGHT\tKEYS\tTARGET KEYS\tBYTES\tTARGET BYTES")
+	for _, b := range brokers {
+		fmt.Fprintf(w, "%s\t%g\t%d\t%.1f\t%d\t%.0f\n", b.Name, b.Weight, b.Keys, b.TargetKeys, b.Bytes, b.TargetBytes)
+	}
+	return w.Flush()
+}
+
+func (c *cli) move(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("move", flag.ExitOnError)
+	key := fs.String("key", "", "key to move")
+	from := fs.String("from", "", "broker holding the key")
+	to := fs.String("to", "", "broker to move the key to")
+	fs.Parse(args)
+	if *key == "" || *from == "" || *to == "" {
+		return errors.New("move: -key, -from and -to are required")
+	}
+	if err := c.client.MoveKey(ctx, *key, *from, *to); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "moved %s from %s to %s\n", *key, *from, *to)
+	return nil
+}
+
+func (c *cli) replicate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replicate", flag.ExitOnError)
+	key := fs.String("key", "", "key to replicate")
+	factor := fs.Int("factor", 0, "number of brokers holding a copy of the key")
+	fs.Parse(args)
+	if *key == "" || *factor < 1 {
+		return errors.New("replicate: -key and a -factor of at least 1 are required")
+	}
+	k, err := c.client.SetReplicationFactor(ctx, *key, *factor)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(k)
+	}
+	fmt.Fprintf(c.out, "%s: master %s, replicas %s\n", k.Key, k.Master, strings.Join(k.Replicas, ","))
+	return nil
+}
+
+func (c *cli) drain(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("drain: expected a broker name")
+	}
+	count, err := c.client.DrainBroker(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "moved %d keys off %s\n", count, args[0])
+	return nil
+}
+
+func (c *cli) undrain(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("undrain: expected a broker name")
+	}
+	return c.client.UndrainBroker(ctx, args[0])
+}
+
+func (c *cli) printResults(results []client.PushResult) error {
+	if c.json {
+		return c.printJSON(results)
+	}
+	failed := 0
+	for _, r := range results {
+		if !r.OK() {
+			failed++
+			fmt.Fprintf(os.Stderr, "failed to push to %s: %s\n", r.Key, r.Error)
+			continue
+		}
+		fmt.Fprintln(c.out, r.ID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d messages failed", failed, len(results))
+	}
+	return nil
+}
+
+func (c *cli) printDelivery(d *client.Delivery) error {
+	if c.json {
+		return c.printJSON(d)
+	}
+	_, err := fmt.Fprintf(c.out, "%s\n", d.Value)
+	return err
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// open opens a file, or stdin if name is "-"
+func open(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
@@ -4,3 +4,90 @@ CREATE TABLE queues (
     broker VARCHAR(255) NOT NULL,
     PRIMARY KEY (queue, broker)
 );
+
+CREATE INDEX queues_queue_pattern_idx ON queues (queue varchar_pattern_ops);
+
+CREATE UNIQUE INDEX queues_one_master_idx ON queues (queue) WHERE is_master;
+
+CREATE TABLE routing_rings (
+    version INTEGER PRIMARY KEY,
+    members TEXT[] NOT NULL,
+    weights DOUBLE PRECISION[] NOT NULL,
+    vnodes INTEGER NOT NULL,
+    replicas INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE route_overrides (
+    queue VARCHAR(255) PRIMARY KEY,
+    master VARCHAR(255) NOT NULL,
+    replicas TEXT[] NOT NULL,
+    ring_version INTEGER NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE leases (
+    id VARCHAR(64) PRIMARY KEY,
+    queue VARCHAR(255) NOT NULL,
+    message JSONB NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    deadline TIMESTAMPTZ NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX leases_deadline_idx ON leases (deadline);
+
+CREATE TABLE key_settings (
+    queue VARCHAR(255) PRIMARY KEY,
+    default_ttl VARCHAR(32) NOT NULL DEFAULT '',
+    max_length INTEGER NOT NULL DEFAULT 0,
+    overflow_policy VARCHAR(32) NOT NULL DEFAULT 'reject',
+    overflow_key VARCHAR(255),
+    weight INTEGER NOT NULL DEFAULT 1,
+    replication_factor INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE scheduled_messages (
+    id VARCHAR(64) PRIMARY KEY,
+    queue VARCHAR(255) NOT NULL,
+    message JSONB NOT NULL,
+    deliver_at TIMESTAMPTZ NOT NULL,
+    claimed_until TIMESTAMPTZ
+);
+
+CREATE INDEX scheduled_messages_deliver_at_idx ON scheduled_messages (deliver_at);
+
+CREATE TABLE scheduled_messages_failed (
+    id VARCHAR(64) PRIMARY KEY,
+    queue VARCHAR(255) NOT NULL,
+    message JSONB NOT NULL,
+    error TEXT NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE key_sequences (
+    queue VARCHAR(255) PRIMARY KEY,
+    seq BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE transactions (
+    id VARCHAR(64) PRIMARY KEY,
+    state VARCHAR(16) NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE transaction_participants (
+    tx_id VARCHAR(64) NOT NULL REFERENCES transactions (id) ON DELETE CASCADE,
+    broker VARCHAR(255) NOT NULL,
+    done BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (tx_id, broker)
+);
+
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    response JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    claimed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    claim VARCHAR(64) NOT NULL DEFAULT ''
+);
@@ -6,20 +6,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	Name    string
-	Address string
-	Health  bool
-	Latency time.Duration
-	Mutex   *sync.Mutex
+	Name     string
+	Address  string
+	Health   bool
+	Latency  time.Duration
+	Labels   map[string]string
+	Weight   float64
+	Mutex    *sync.Mutex
+	draining atomic.Bool
 }
 
 func NewBroker(name string, address string) *Client {
@@ -28,10 +33,22 @@ func NewBroker(name string, address string) *Client {
 		Address: address,
 		Health:  false,
 		Latency: 0,
+		Weight:  1,
 		Mutex:   &sync.Mutex{},
 	}
 }
 
+// Draining reports whether no keys may be assigned to the broker
+func (b *Client) Draining() bool {
+	return b.draining.Load()
+}
+
+// SetDraining sets whether no keys may be assigned to the broker. It is safe to call
+// while other goroutines read it.
+func (b *Client) SetDraining(draining bool) {
+	b.draining.Store(draining)
+}
+
 func (b *Client) NewRequest(method string, url string, route string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequest(method, url+route, body)
 	if err != nil {
@@ -50,11 +67,43 @@ func (b *Client) HealthCheck() error {
 	return err
 }
 
-func ensureStatusOK(resp *http.Response, successCode int) error {
-	if resp.StatusCode != successCode {
-		return errors.New("status code not OK")
+// ErrKeyNotFound is returned when the broker doesn't hold the key of a request
+var ErrKeyNotFound = errors.New("key not found")
+
+// errMessageNotFound is returned when the broker holds the key but not the message of
+// a request
+var errMessageNotFound = errors.New("message not found")
+
+// errorBody is the body of a failed broker response
+type errorBody struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// notFound returns the error a 404 response stands for. Only a body saying the key or
+// the message is unknown maps to a sentinel; any other 404, e.g. for a route an older
+// broker doesn't serve, is a plain failure.
+func notFound(data []byte) error {
+	body := errorBody{}
+	if err := json.Unmarshal(data, &body); err == nil {
+		switch {
+		case body.Code == "key_not_found" || body.Error == ErrKeyNotFound.Error():
+			return ErrKeyNotFound
+		case body.Code == "message_not_found" || body.Error == errMessageNotFound.Error():
+			return errMessageNotFound
+		}
 	}
-	return nil
+	return fmt.Errorf("status code not OK: %d %s", http.StatusNotFound, strings.TrimSpace(string(data)))
+}
+
+func ensureStatusOK(resp *http.Response, data []byte, successCode int) error {
+	if resp.StatusCode == successCode {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return notFound(data)
+	}
+	return errors.New("status code not OK")
 }
 
 func processRequest(req *http.Request, successCode int) ([]byte, error) {
@@ -65,8 +114,11 @@ func processRequest(req *http.Request, successCode int) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 
-	err = ensureStatusOK(resp, successCode)
+	err = ensureStatusOK(resp, data, successCode)
 
 	if err != nil {
 		return nil, err
@@ -109,16 +161,25 @@ func (b *Client) Push(req *types.Element) error {
 		"{key}": req.Key,
 	}
 	apiURL := substringReplace(routes.RoutePush, replaceDict)
-	request := map[string][]byte{
-		"value": req.Value,
-	}
-	err := b.Do(http.MethodPost, apiURL, 200, request, nil)
+	err := b.Do(http.MethodPost, apiURL, 200, req, nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// PushBatch pushes several messages, possibly for different keys, in a single call
+func (b *Client) PushBatch(elems []types.Element) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	req := &types.PushElementsRequest{
+		Messages: elems,
+	}
+	err := b.Do(http.MethodPost, routes.RoutePushBatch, 200, req, nil)
+	return err
+}
+
 // Front Get front value of any key that is a master and not empty
 func (b *Client) Front() (*types.Element, error) {
 	b.Mutex.Lock()
@@ -132,6 +193,76 @@ func (b *Client) Front() (*types.Element, error) {
 	return res, nil
 }
 
+// Pop pops the front message of the key
+func (b *Client) Pop(key string) (*types.Element, error) {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{key}": key,
+	}
+	apiURL := substringReplace(routes.RoutePop, replaceDict)
+	req := map[string]string{
+		"key": key,
+	}
+
+	res := &types.Element{}
+	err := b.Do(http.MethodPost, apiURL, 200, req, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Peek returns the front message of the key without removing it
+func (b *Client) Peek(key string) (*types.Element, error) {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{key}": key,
+	}
+	apiURL := substringReplace(routes.RoutePeek, replaceDict)
+
+	res := &types.Element{}
+	err := b.Do(http.MethodGet, apiURL, 200, nil, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Size returns the number of messages and the queued bytes of the key
+func (b *Client) Size(key string) (*types.KeySizeResponse, error) {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{key}": key,
+	}
+	apiURL := substringReplace(routes.RouteSize, replaceDict)
+
+	res := &types.KeySizeResponse{}
+	err := b.Do(http.MethodGet, apiURL, 200, nil, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Stats returns the number of keys held by the broker and their queued bytes
+func (b *Client) Stats() (*types.BrokerStatsResponse, error) {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	res := &types.BrokerStatsResponse{}
+	err := b.Do(http.MethodGet, routes.RouteStats, 200, nil, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 // AddKey adds a queue to the broker
 func (b *Client) AddKey(key string, isMaster bool) error {
 	b.Mutex.Lock()
@@ -145,6 +276,41 @@ func (b *Client) AddKey(key string, isMaster bool) error {
 	return err
 }
 
+// RemoveKey deletes a queue and its messages from the broker. Removing a key the
+// broker doesn't hold succeeds.
+func (b *Client) RemoveKey(key string) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{key}": key,
+	}
+	apiURL := substringReplace(routes.RouteKeyDelete, replaceDict)
+	err := b.Do(http.MethodDelete, apiURL, 200, nil, nil)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// RemoveMessage deletes the message with the given ID from the key. Removing a message
+// the broker doesn't hold succeeds.
+func (b *Client) RemoveMessage(key, id string) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{key}": key,
+		"{id}":  id,
+	}
+	apiURL := substringReplace(routes.RouteMessage, replaceDict)
+	err := b.Do(http.MethodDelete, apiURL, 200, nil, nil)
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, errMessageNotFound) {
+		return nil
+	}
+	return err
+}
+
 // Remove pops a message from queue \"queueName\"
 func (b *Client) Remove(key string) error {
 	b.Mutex.Lock()
@@ -177,10 +343,10 @@ func (b *Client) KeySetMaster(key string, masterStatus bool) error {
 	return err
 }
 
-func (b *Client) Import(key string, isMaster bool, values [][]byte) error {
+func (b *Client) Import(key string, isMaster bool, messages []types.Element) error {
 	req := &types.ImportRequest{
 		Key:      key,
-		Values:   values,
+		Messages: messages,
 		IsMaster: isMaster,
 	}
 	err := b.Do(http.MethodPost, routes.RouteImport, 200, req, nil)
@@ -199,6 +365,48 @@ func (b *Client) Export(key string) (*types.ExportResponse, error) {
 	return res, nil
 }
 
+// TxPrepare stages messages of a transaction on the broker. Staged messages are
+// invisible until the transaction is committed.
+func (b *Client) TxPrepare(id string, elems []types.Element) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{id}": id,
+	}
+	apiURL := substringReplace(routes.RouteTxPrepare, replaceDict)
+	req := &types.PushElementsRequest{
+		Messages: elems,
+	}
+	return b.Do(http.MethodPost, apiURL, 200, req, nil)
+}
+
+// TxCommit appends the staged messages of a transaction to their keys. Committing a
+// transaction the broker doesn't know succeeds, so commits can be retried.
+func (b *Client) TxCommit(id string) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{id}": id,
+	}
+	apiURL := substringReplace(routes.RouteTxCommit, replaceDict)
+	return b.Do(http.MethodPost, apiURL, 200, nil, nil)
+}
+
+// TxAbort drops the staged messages of a transaction. Aborting a transaction the
+// broker doesn't know succeeds, so aborts can be retried.
+func (b *Client) TxAbort(id string) error {
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	replaceDict := map[string]string{
+		"{id}": id,
+	}
+	apiURL := substringReplace(routes.RouteTxAbort, replaceDict)
+	return b.Do(http.MethodPost, apiURL, 200, nil, nil)
+}
+
 func substringReplace(s string, replaceDict map[string]string) string {
 	for k, v := range replaceDict {
 		s = strings.Replace(s, k, v, -1)
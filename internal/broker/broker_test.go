@@ -0,0 +1,41 @@
+package broker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotFound(t *testing.T) {
+	tests := []struct {
+		body        string
+		keyNotFound bool
+	}{
+		{`{"code":"key_not_found"}`, true},
+		{`{"error":"key not found"}`, true},
+		{`{"code":"message_not_found"}`, false},
+		{"404 page not found", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(tt.body))
+		}))
+		b := NewBroker("broker1", srv.URL)
+		err := b.Do(http.MethodGet, "/key/orders/front", 200, nil, nil)
+		if got := errors.Is(err, ErrKeyNotFound); got != tt.keyNotFound || err == nil {
+			t.Errorf("Do() with a 404 of %q = %v, want key not found %v", tt.body, err, tt.keyNotFound)
+		}
+		removeErr := b.RemoveKey("orders")
+		if (removeErr == nil) != tt.keyNotFound {
+			t.Errorf("RemoveKey() with a 404 of %q = %v", tt.body, removeErr)
+		}
+		messageErr := b.RemoveMessage("orders", "m1")
+		srv.Close()
+		if wantOK := tt.keyNotFound || tt.body == `{"code":"message_not_found"}`; (messageErr == nil) != wantOK {
+			t.Errorf("RemoveMessage() with a 404 of %q = %v", tt.body, messageErr)
+		}
+	}
+}
@@ -1,11 +1,20 @@
 package routes
 
 const (
-	RoutePush   = "/key/{key}/push"
-	RoutePop    = "/key/{key}/pop"
-	RouteFront  = "/front"
-	RouteKey    = "/key"
-	RouteMaster = "/key/{key}/set_master"
-	RouteExport = "/export"
-	RouteImport = "/import"
+	RoutePush      = "/key/{key}/push"
+	RoutePushBatch = "/push/batch"
+	RoutePop       = "/key/{key}/pop"
+	RoutePeek      = "/key/{key}/front"
+	RouteSize      = "/key/{key}/size"
+	RouteFront     = "/front"
+	RouteStats     = "/stats"
+	RouteKey       = "/key"
+	RouteKeyDelete = "/key/{key}"
+	RouteMessage   = "/key/{key}/message/{id}"
+	RouteMaster    = "/key/{key}/set_master"
+	RouteExport    = "/export"
+	RouteImport    = "/import"
+	RouteTxPrepare = "/tx/{id}/prepare"
+	RouteTxCommit  = "/tx/{id}/commit"
+	RouteTxAbort   = "/tx/{id}/abort"
 )
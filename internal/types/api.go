@@ -0,0 +1,89 @@
+package types
+
+// Error codes of the error envelope
+const (
+	ErrorInvalidRequest = "invalid_request"
+	ErrorNotFound       = "not_found"
+	ErrorKeyNotFound    = "key_not_found"
+	ErrorLeaseNotFound  = "lease_not_found"
+	ErrorBrokerNotFound = "broker_not_found"
+	ErrorQueueFull      = "queue_full"
+	ErrorPushInProgress = "push_in_progress"
+	ErrorTxAborted      = "transaction_aborted"
+	ErrorTooLarge       = "message_too_large"
+	ErrorNoBrokers      = "no_broker_available"
+	ErrorInternal       = "internal"
+)
+
+// ErrorResponse is the body of every failed request of the v1 API
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError is a machine-readable code, a human-readable message and optional
+// details of an error
+type APIError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type OKResponse struct {
+	Message string `json:"message"`
+}
+
+type NackResponse struct {
+	Message      string `json:"message"`
+	DeadLettered bool   `json:"dead_lettered"`
+}
+
+type CountResponse struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+type KeySettingsResponse struct {
+	Key               string      `json:"key"`
+	Settings          KeySettings `json:"settings"`
+	ReplicationFactor int         `json:"replication_factor"`
+	Size              int         `json:"size"`
+	Bytes             int64       `json:"bytes"`
+}
+
+type DeadLettersResponse struct {
+	Key      string    `json:"key"`
+	Messages []Element `json:"messages"`
+}
+
+type KeysResponse struct {
+	Keys []KeyAssignment `json:"keys"`
+}
+
+type BrokersResponse struct {
+	Brokers []BrokerStatus `json:"brokers"`
+}
+
+// BrokerDistribution is the number of keys and the queued bytes of a broker next to
+// its target share of them, in proportion to its weight
+type BrokerDistribution struct {
+	Name        string  `json:"name"`
+	Weight      float64 `json:"weight"`
+	Keys        int     `json:"keys"`
+	TargetKeys  float64 `json:"target_keys"`
+	Bytes       int64   `json:"bytes"`
+	TargetBytes float64 `json:"target_bytes"`
+}
+
+type DistributionResponse struct {
+	Brokers []BrokerDistribution `json:"brokers"`
+}
+
+// RoutingResponse is the routing mode of the zookeeper and, with consistent hash
+// routing, its ring and the number of keys routed by an override
+type RoutingResponse struct {
+	Mode      string   `json:"mode"`
+	Version   int      `json:"version,omitempty"`
+	Members   []string `json:"members,omitempty"`
+	Vnodes    int      `json:"vnodes,omitempty"`
+	Overrides int      `json:"overrides"`
+}
@@ -1,17 +1,86 @@
 package types
 
+import "time"
+
 type PushRequest struct {
-	Key   string `json:"key" binding:"required"`
-	Value []byte `json:"value" binding:"required"`
+	Key       string            `json:"key" binding:"required"`
+	Value     []byte            `json:"value" binding:"required"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	TTL       string            `json:"ttl,omitempty"`
+	Delay     string            `json:"delay,omitempty"`
+	DeliverAt *time.Time        `json:"deliver_at,omitempty"`
+	Priority  int               `json:"priority,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// ReplicationFactor sets the number of brokers holding a copy of the key if the
+	// push creates it
+	ReplicationFactor int `json:"replication_factor,omitempty"`
+
+	// Blob is set instead of Value by raw pushes whose body was stored as a blob
+	Blob string `json:"-"`
 }
 
 type PushResponse struct {
-	Message string `json:"message"`
+	ID        string     `json:"id"`
+	Key       string     `json:"key"`
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+}
+
+type BatchPushRequest struct {
+	Messages []PushRequest `json:"messages" binding:"required,dive"`
+}
+
+type PushElementsRequest struct {
+	Messages []Element `json:"messages" binding:"required"`
 }
 
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+type PushResult struct {
+	ID        string     `json:"id,omitempty"`
+	Key       string     `json:"key"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+}
+
+type BatchPushResponse struct {
+	Results []PushResult `json:"results"`
+}
+
+// TransactionPushRequest is a list of messages pushed as one all-or-nothing unit
+type TransactionPushRequest struct {
+	Messages []PushRequest `json:"messages" binding:"required,min=1,dive"`
+}
+
+type TransactionPushResponse struct {
+	ID      string       `json:"id"`
+	Results []PushResult `json:"results"`
+}
+
+// MaxPriority is the highest priority of a message. Brokers pop the messages of a key
+// by descending priority, in FIFO order within each priority.
+const MaxPriority = 9
+
+// Element is a message of a key. ID and Timestamp are assigned by the zookeeper when
+// the message is pushed and are kept by the brokers through exports and imports. Seq
+// is the position of the message in its key, assigned when it is handed to the brokers.
+// A value too large to be enqueued is stored in the blob directory and Blob names it.
 type Element struct {
-	Key   string `json:"key" binding:"required"`
-	Value []byte `json:"value" binding:"required"`
+	ID        string            `json:"id"`
+	Key       string            `json:"key" binding:"required"`
+	Value     []byte            `json:"value" binding:"required"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	DeliverAt *time.Time        `json:"deliver_at,omitempty"`
+	Priority  int               `json:"priority"`
+	Seq       int64             `json:"seq,omitempty"`
+	Blob      string            `json:"blob,omitempty"`
 }
 
 type ExportRequest struct {
@@ -19,18 +88,37 @@ type ExportRequest struct {
 }
 
 type ExportResponse struct {
-	Values [][]byte `json:"values" binding:"required"`
+	Messages []Element `json:"messages" binding:"required"`
 }
 
 type ImportRequest struct {
-	Key      string   `json:"key" binding:"required"`
-	Values   [][]byte `json:"values" binding:"required"`
-	IsMaster bool     `json:"isMaster" binding:"required"`
+	Key      string    `json:"key" binding:"required"`
+	Messages []Element `json:"messages" binding:"required"`
+	IsMaster bool      `json:"isMaster" binding:"required"`
 }
 
+// PopResponse holds the leased message, or a null delivery if every queue was empty
 type PopResponse struct {
-	Key   string `json:"key"`
-	Value []byte `json:"value"`
+	Delivery *Delivery `json:"delivery"`
+}
+
+// Delivery is a message leased to a consumer. It is redelivered unless it is
+// acknowledged before Deadline.
+type Delivery struct {
+	ID        string            `json:"id"`
+	Key       string            `json:"key"`
+	Value     []byte            `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Priority  int               `json:"priority"`
+	Seq       int64             `json:"seq"`
+	Deadline  time.Time         `json:"deadline"`
+	Attempts  int               `json:"attempts"`
+}
+
+// PeekResponse holds the front message of a key, or a null message if it is empty
+type PeekResponse struct {
+	Message *Element `json:"message"`
 }
 
 type AddKeyRequest struct {
@@ -41,3 +129,59 @@ type AddKeyRequest struct {
 type KeySetMasterRequest struct {
 	MasterStatus bool `json:"masterStatus"`
 }
+
+const (
+	OverflowReject     = "reject"
+	OverflowDropOldest = "drop_oldest"
+	OverflowRoute      = "route"
+)
+
+type KeySettings struct {
+	DefaultTTL     string `json:"default_ttl"`
+	MaxLength      int    `json:"max_length"`
+	OverflowPolicy string `json:"overflow_policy"`
+	OverflowKey    string `json:"overflow_key,omitempty"`
+	Weight         int    `json:"weight,omitempty"`
+}
+
+type KeySizeResponse struct {
+	Size  int   `json:"size"`
+	Bytes int64 `json:"bytes"`
+}
+
+// BrokerStatsResponse is the number of keys held by a broker and their queued bytes
+type BrokerStatsResponse struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// KeyAssignment is the master and the replica brokers of a key
+type KeyAssignment struct {
+	Key      string   `json:"key"`
+	Master   string   `json:"master"`
+	Replicas []string `json:"replicas"`
+}
+
+// BrokerStatus is the health of a broker and the number of keys it holds
+type BrokerStatus struct {
+	Name      string            `json:"name"`
+	Address   string            `json:"address"`
+	Healthy   bool              `json:"healthy"`
+	Draining  bool              `json:"draining"`
+	Latency   string            `json:"latency"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Keys      int               `json:"keys"`
+	MasterFor int               `json:"master_for"`
+}
+
+// ReplicationRequest sets the number of brokers holding a copy of a key, the master
+// included
+type ReplicationRequest struct {
+	Factor int `json:"factor" binding:"required,min=1"`
+}
+
+// MoveKeyRequest moves the copy of a key held by one broker to another broker
+type MoveKeyRequest struct {
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
+}
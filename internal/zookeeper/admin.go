@@ -0,0 +1,243 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"Zookeeper/internal/types"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+var errBrokerNotFound = errors.New("broker not found")
+
+// keyAssignments returns the master and the replicas of every key matching the glob
+// pattern, or of every key if it is empty
+func (s *Zookeeper) keyAssignments(pattern string) ([]types.KeyAssignment, error) {
+	rows, err := s.db.Query(`SELECT queue, broker, is_master FROM queues WHERE queue LIKE $1 ESCAPE '\' ORDER BY queue, broker`, likePattern(pattern))
+	if err != nil {
+		log.Warnf("Couldn't list keys: %s", err.Error())
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Warnf("Couldn't close rows: %s", err.Error())
+		}
+	}(rows)
+
+	res := []types.KeyAssignment{}
+	for rows.Next() {
+		var key, brokerName string
+		var isMaster bool
+		if err := rows.Scan(&key, &brokerName, &isMaster); err != nil {
+			return nil, err
+		}
+		if len(res) == 0 || res[len(res)-1].Key != key {
+			res = append(res, types.KeyAssignment{Key: key, Replicas: []string{}})
+		}
+		if isMaster {
+			res[len(res)-1].Master = brokerName
+		} else {
+			res[len(res)-1].Replicas = append(res[len(res)-1].Replicas, brokerName)
+		}
+	}
+	return res, rows.Err()
+}
+
+// brokerStatuses returns the health of every broker and the number of keys it holds
+func (s *Zookeeper) brokerStatuses() ([]types.BrokerStatus, error) {
+	keys, err := s.keyAssignments(anyKey)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]*types.BrokerStatus{}
+	for name := range s.brokers {
+		counts[name] = &types.BrokerStatus{}
+	}
+	for _, k := range keys {
+		if c, ok := counts[k.Master]; ok {
+			c.Keys++
+			c.MasterFor++
+		}
+		for _, r := range k.Replicas {
+			if c, ok := counts[r]; ok {
+				c.Keys++
+			}
+		}
+	}
+
+	res := []types.BrokerStatus{}
+	for name, b := range s.brokers {
+		res = append(res, types.BrokerStatus{
+			Name:      name,
+			Address:   b.Address,
+			Healthy:   b.Health,
+			Draining:  b.Draining(),
+			Latency:   b.Latency.String(),
+			Labels:    b.Labels,
+			Keys:      counts[name].Keys,
+			MasterFor: counts[name].MasterFor,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// holdsKey returns whether the broker holds a copy of the key, and whether it is the master one
+func (s *Zookeeper) holdsKey(key string, b *broker.Client) (bool, bool, error) {
+	var isMaster bool
+	err := s.db.QueryRow("SELECT is_master FROM queues WHERE queue = $1 AND broker = $2", key, b.Name).Scan(&isMaster)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, isMaster, nil
+}
+
+// moveKeyBetween moves the copy of the key held by the broker named from to the
+// broker named to
+func (s *Zookeeper) moveKeyBetween(key, from, to string) error {
+	source, target := s.brokers[from], s.brokers[to]
+	if source == nil || target == nil {
+		return errBrokerNotFound
+	}
+	if source == target {
+		return invalid(errors.New("from and to must be different brokers"))
+	}
+	if !target.Health {
+		return invalid(fmt.Errorf("broker %s is not healthy", to))
+	}
+
+	held, isMaster, err := s.holdsKey(key, source)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return errKeyNotFound
+	}
+	held, _, err = s.holdsKey(key, target)
+	if err != nil {
+		return err
+	}
+	if held {
+		return invalid(fmt.Errorf("broker %s already holds key %s", to, key))
+	}
+	return s.moveKey(key, isMaster, source, target)
+}
+
+// drainBroker stops assigning keys to the broker and moves every key it holds to a
+// healthy broker without a copy of the key, chosen like a replacement after a failure.
+// It returns the number of moved keys.
+func (s *Zookeeper) drainBroker(name string) (int, error) {
+	source := s.brokers[name]
+	if source == nil {
+		return 0, errBrokerNotFound
+	}
+	source.SetDraining(true)
+	log.WithFields(log.Fields{
+		"broker": name,
+	}).Info("Draining broker")
+
+	keys, err := s.keyAssignments(anyKey)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, k := range keys {
+		holders := map[string]bool{k.Master: true}
+		for _, r := range k.Replicas {
+			holders[r] = true
+		}
+		if !holders[name] {
+			continue
+		}
+
+		var keep []string
+		for holder := range holders {
+			if holder != name {
+				keep = append(keep, holder)
+			}
+		}
+		target := s.replacementBroker(k.Key, keep, append(keep, name))
+		if target == nil {
+			return moved, fmt.Errorf("no broker can take over key %s", k.Key)
+		}
+		if err := s.moveKey(k.Key, k.Master == name, source, target); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	log.WithFields(log.Fields{
+		"broker": name,
+		"count":  moved,
+	}).Info("Drained broker")
+	return moved, nil
+}
+
+// ListKeys lists every key with its master and replica brokers, or the keys matching
+// the glob pattern in the pattern query parameter
+func (s *Zookeeper) ListKeys(c *gin.Context) {
+	keys, err := s.keyAssignments(c.Query("pattern"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.KeysResponse{Keys: keys})
+}
+
+// ListBrokers lists every broker with its health, latency and number of keys
+func (s *Zookeeper) ListBrokers(c *gin.Context) {
+	brokers, err := s.brokerStatuses()
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.BrokersResponse{Brokers: brokers})
+}
+
+// MoveKey moves the copy of a key held by one broker to another broker
+func (s *Zookeeper) MoveKey(c *gin.Context) {
+	req := &types.MoveKeyRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	if err := s.moveKeyBetween(c.Param("key"), req.From, req.To); err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.OKResponse{Message: "ok"})
+}
+
+// DrainBroker moves every key off a broker and stops assigning new keys to it
+func (s *Zookeeper) DrainBroker(c *gin.Context) {
+	count, err := s.drainBroker(c.Param("name"))
+	if err != nil {
+		failWith(c, err, map[string]interface{}{"count": count})
+		return
+	}
+	respond(c, http.StatusOK, &types.CountResponse{Message: "ok", Count: count})
+}
+
+// UndrainBroker lets keys be assigned to a drained broker again
+func (s *Zookeeper) UndrainBroker(c *gin.Context) {
+	b := s.brokers[c.Param("name")]
+	if b == nil {
+		fail(c, errBrokerNotFound)
+		return
+	}
+	b.SetDraining(false)
+	respond(c, http.StatusOK, &types.OKResponse{Message: "ok"})
+}
+
+// GetRouting returns the routing mode and, with consistent hash routing, the ring
+func (s *Zookeeper) GetRouting(c *gin.Context) {
+	respond(c, http.StatusOK, s.routingStatus())
+}
@@ -0,0 +1,224 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// apiVersion is the prefix of the current API. The same routes are served without
+// the prefix for clients of the unversioned API until it is removed.
+const apiVersion = "/v1"
+
+// legacyContextKey marks requests made to the unversioned routes
+const legacyContextKey = "legacy"
+
+// parameter is a path, query or header parameter of an endpoint
+type parameter struct {
+	name        string
+	in          string
+	description string
+}
+
+// endpoint is a route of the API. The request and response types describe it in the
+// OpenAPI document; a nil request means the route has no body, unless rawRequest is
+// set for routes reading the body as a raw value.
+type endpoint struct {
+	method      string
+	path        string
+	summary     string
+	handler     gin.HandlerFunc
+	parameters  []parameter
+	request     interface{}
+	rawRequest  bool
+	response    interface{}
+	contentType string
+}
+
+// endpoints returns every route of the API
+func (s *Zookeeper) endpoints() []endpoint {
+	wait := parameter{name: "wait", in: "query", description: "How long to wait for a message, e.g. 20s"}
+	accept := parameter{name: "Accept", in: "header", description: "application/octet-stream to get the raw value with the message fields as X-Message-* headers"}
+	return []endpoint{
+		{method: http.MethodPost, path: "/push", summary: "Push a message", handler: s.Push,
+			parameters: []parameter{{name: "Idempotency-Key", in: "header", description: "Deduplicates retried pushes"}},
+			request:    &types.PushRequest{}, response: &types.PushResponse{}},
+		{method: http.MethodPost, path: "/key/:key/push", summary: "Push the request body as a raw message value", handler: s.PushRaw,
+			parameters: []parameter{
+				{name: "Idempotency-Key", in: "header", description: "Deduplicates retried pushes"},
+				{name: "X-Message-TTL", in: "header", description: "Time to live of the message, e.g. 1h"},
+				{name: "X-Message-Delay", in: "header", description: "Delay before the message is delivered, e.g. 30s"},
+				{name: "X-Message-Deliver-At", in: "header", description: "RFC 3339 time at which the message is delivered"},
+				{name: "X-Message-Priority", in: "header", description: "Priority of the message"},
+			},
+			rawRequest: true, response: &types.PushResponse{}},
+		{method: http.MethodPost, path: "/push/batch", summary: "Push several messages", handler: s.PushBatch,
+			request: &types.BatchPushRequest{}, response: &types.BatchPushResponse{}},
+		{method: http.MethodPost, path: "/push/transaction", summary: "Push several messages as one unit", handler: s.PushTransaction,
+			request: &types.TransactionPushRequest{}, response: &types.TransactionPushResponse{}},
+		{method: http.MethodPost, path: "/pop", summary: "Lease a message of any key", handler: s.Pop,
+			parameters: []parameter{wait, accept, {name: "pattern", in: "query", description: "Glob pattern of the keys, e.g. orders.*"}},
+			response:   &types.PopResponse{}},
+		{method: http.MethodPost, path: "/key/:key/pop", summary: "Lease a message of a key", handler: s.PopKey,
+			parameters: []parameter{wait, accept}, response: &types.PopResponse{}},
+		{method: http.MethodGet, path: "/key/:key/peek", summary: "Get the front message of a key", handler: s.PeekKey,
+			response: &types.PeekResponse{}},
+		{method: http.MethodPost, path: "/ack/:id", summary: "Acknowledge a leased message", handler: s.Ack,
+			response: &types.OKResponse{}},
+		{method: http.MethodPost, path: "/nack/:id", summary: "Release a leased message for redelivery", handler: s.Nack,
+			response: &types.NackResponse{}},
+		{method: http.MethodGet, path: "/subscribe", summary: "Stream leased messages as Server-Sent Events", handler: s.Subscribe,
+			parameters: []parameter{
+				{name: "keys", in: "query", description: "Comma-separated keys or glob patterns, any key if empty"},
+				{name: "prefetch", in: "query", description: "Number of unacknowledged messages"},
+			},
+			response: &types.Delivery{}, contentType: "text/event-stream"},
+		{method: http.MethodGet, path: "/admin/key/:key/settings", summary: "Get the retention settings of a key", handler: s.GetKeySettings,
+			response: &types.KeySettingsResponse{}},
+		{method: http.MethodPut, path: "/admin/key/:key/settings", summary: "Set the retention settings of a key", handler: s.SetKeySettings,
+			request: &types.KeySettings{}, response: &types.OKResponse{}},
+		{method: http.MethodGet, path: "/admin/dlq/:key", summary: "List the dead letters of a key", handler: s.ListDeadLetters,
+			response: &types.DeadLettersResponse{}},
+		{method: http.MethodPost, path: "/admin/dlq/:key/redrive", summary: "Push the dead letters of a key again", handler: s.RedriveDeadLetters,
+			response: &types.CountResponse{}},
+		{method: http.MethodDelete, path: "/admin/dlq/:key", summary: "Delete the dead letters of a key", handler: s.PurgeDeadLetters,
+			response: &types.CountResponse{}},
+		{method: http.MethodGet, path: "/admin/keys", summary: "List keys with their brokers", handler: s.ListKeys,
+			parameters: []parameter{{name: "pattern", in: "query", description: "Glob pattern of the keys to list"}},
+			response:   &types.KeysResponse{}},
+		{method: http.MethodPost, path: "/admin/key/:key/move", summary: "Move a key to another broker", handler: s.MoveKey,
+			request: &types.MoveKeyRequest{}, response: &types.OKResponse{}},
+		{method: http.MethodPut, path: "/admin/key/:key/replication", summary: "Set the number of brokers holding a copy of a key", handler: s.SetReplicationFactor,
+			request: &types.ReplicationRequest{}, response: &types.KeyAssignment{}},
+		{method: http.MethodGet, path: "/admin/brokers", summary: "List brokers with their health", handler: s.ListBrokers,
+			response: &types.BrokersResponse{}},
+		{method: http.MethodGet, path: "/admin/distribution", summary: "Compare the load of brokers with their target share", handler: s.GetDistribution,
+			response: &types.DistributionResponse{}},
+		{method: http.MethodGet, path: "/admin/routing", summary: "Get the routing mode and the ring", handler: s.GetRouting,
+			response: &types.RoutingResponse{}},
+		{method: http.MethodPost, path: "/admin/broker/:name/drain", summary: "Move every key off a broker", handler: s.DrainBroker,
+			response: &types.CountResponse{}},
+		{method: http.MethodDelete, path: "/admin/broker/:name/drain", summary: "Assign keys to a drained broker again", handler: s.UndrainBroker,
+			response: &types.OKResponse{}},
+	}
+}
+
+// deprecated marks a request as made to the unversioned API and sets the deprecation
+// headers pointing to its successor
+func deprecated(sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(legacyContextKey, true)
+		c.Header("Deprecation", "true")
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", "<"+apiVersion+c.Request.URL.Path+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
+
+// legacySunset returns the configured removal date of the unversioned API, if any
+func legacySunset() time.Time {
+	value := viper.GetString("legacy_api_sunset")
+	if value == "" {
+		return time.Time{}
+	}
+	sunset, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"legacy_api_sunset": value,
+		}).Warnf("Couldn't parse sunset date: %s", err.Error())
+		return time.Time{}
+	}
+	return sunset
+}
+
+func isLegacy(c *gin.Context) bool {
+	return c.GetBool(legacyContextKey)
+}
+
+// respond writes a response, in the shape of the unversioned API for legacy requests
+func respond(c *gin.Context, status int, body interface{}) {
+	if isLegacy(c) {
+		body = legacyBody(body)
+	}
+	c.JSON(status, body)
+}
+
+// fail writes the error envelope matching an error, or the bare error message for
+// legacy requests
+func fail(c *gin.Context, err error) {
+	failWith(c, err, nil)
+}
+
+// failWith writes the error envelope of an error with details
+func failWith(c *gin.Context, err error, details map[string]interface{}) {
+	c.JSON(httpStatus(err), errorBody(c, err, details))
+}
+
+func errorBody(c *gin.Context, err error, details map[string]interface{}) interface{} {
+	if isLegacy(c) {
+		body := gin.H{"error": err.Error()}
+		for k, v := range details {
+			body[k] = v
+		}
+		return body
+	}
+	return &types.ErrorResponse{Error: types.APIError{Code: errorCode(err), Message: err.Error(), Details: details}}
+}
+
+// legacyBody converts the responses whose shape changed in the v1 API
+func legacyBody(body interface{}) interface{} {
+	switch b := body.(type) {
+	case *types.PushResponse:
+		return gin.H{"message": "ok", "id": b.ID, "deliver_at": b.DeliverAt}
+	case *types.PopResponse:
+		if b.Delivery == nil {
+			return gin.H{"message": "Queue is empty"}
+		}
+		return legacyDelivery(b.Delivery)
+	case *types.PeekResponse:
+		if b.Message == nil {
+			return gin.H{"message": "Queue is empty"}
+		}
+		return gin.H{
+			"message":   "ok",
+			"id":        b.Message.ID,
+			"key":       b.Message.Key,
+			"value":     b.Message.Value,
+			"timestamp": b.Message.Timestamp,
+			"headers":   b.Message.Headers,
+			"priority":  b.Message.Priority,
+		}
+	}
+	return body
+}
+
+func legacyDelivery(d *types.Delivery) gin.H {
+	return gin.H{
+		"message":   "ok",
+		"id":        d.ID,
+		"key":       d.Key,
+		"value":     d.Value,
+		"timestamp": d.Timestamp,
+		"headers":   d.Headers,
+		"priority":  d.Priority,
+		"seq":       d.Seq,
+		"deadline":  d.Deadline,
+		"attempts":  d.Attempts,
+	}
+}
+
+// noRoute answers unknown v1 routes with the error envelope
+func noRoute(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.URL.Path, apiVersion+"/") {
+		c.String(http.StatusNotFound, "404 page not found")
+		return
+	}
+	c.JSON(http.StatusNotFound, &types.ErrorResponse{Error: types.APIError{Code: types.ErrorNotFound, Message: "route not found"}})
+}
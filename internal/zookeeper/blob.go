@@ -0,0 +1,142 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var errMessageTooLarge = errors.New("message is too large")
+
+// maxMessageSize returns the largest accepted message value in bytes, or 0 if values
+// aren't limited
+func maxMessageSize() int64 {
+	return viper.GetInt64("max_message_size")
+}
+
+// blobThreshold returns the value size above which a value is stored in the blob
+// directory and only a reference to it is enqueued, or 0 if values are always enqueued
+func blobThreshold() int64 {
+	if viper.GetString("blob_dir") == "" {
+		return 0
+	}
+	return viper.GetInt64("blob_threshold")
+}
+
+// checkMessageSize rejects values larger than the maximum message size
+func checkMessageSize(size int64) error {
+	if max := maxMessageSize(); max > 0 && size > max {
+		return fmt.Errorf("%w: %d bytes, the maximum is %d", errMessageTooLarge, size, max)
+	}
+	return nil
+}
+
+// blobPath returns the file of a blob. The blob directory must be shared by every
+// zookeeper of the cluster.
+func blobPath(blob string) string {
+	return filepath.Join(viper.GetString("blob_dir"), blob)
+}
+
+// storeBlob writes a value read from r to a new blob and returns its name
+func storeBlob(r io.Reader) (string, error) {
+	blob := newID()
+	if err := os.MkdirAll(viper.GetString("blob_dir"), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(viper.GetString("blob_dir"), blob+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), blobPath(blob))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.WithFields(log.Fields{
+			"blob": blob,
+		}).Warnf("Couldn't store blob: %s", err.Error())
+		return "", err
+	}
+	return blob, nil
+}
+
+// readPayload reads a raw message value. Values up to the blob threshold are returned
+// in memory; larger ones are streamed to a blob whose name is returned instead.
+func readPayload(r io.Reader) ([]byte, string, error) {
+	threshold := blobThreshold()
+	if threshold <= 0 {
+		value, err := io.ReadAll(r)
+		return value, "", err
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r, threshold+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(head)) <= threshold {
+		return head, "", nil
+	}
+	blob, err := storeBlob(io.MultiReader(bytes.NewReader(head), r))
+	return nil, blob, err
+}
+
+// openValue returns a reader of the value of a message and its size, reading it from
+// its blob if it has one
+func openValue(elem *types.Element) (io.ReadCloser, int64, error) {
+	if elem.Blob == "" {
+		return io.NopCloser(bytes.NewReader(elem.Value)), int64(len(elem.Value)), nil
+	}
+	f, err := os.Open(blobPath(elem.Blob))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// loadValue reads the blob of a message into its value
+func loadValue(elem *types.Element) error {
+	if elem.Blob == "" || elem.Value != nil {
+		return nil
+	}
+	value, err := os.ReadFile(blobPath(elem.Blob))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key":  elem.Key,
+			"id":   elem.ID,
+			"blob": elem.Blob,
+		}).Warnf("Couldn't read blob: %s", err.Error())
+		return err
+	}
+	elem.Value = value
+	return nil
+}
+
+// removeBlob deletes the blob of a message that left the queue for good
+func removeBlob(elem *types.Element) {
+	if elem == nil || elem.Blob == "" {
+		return
+	}
+	if err := os.Remove(blobPath(elem.Blob)); err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{
+			"key":  elem.Key,
+			"id":   elem.ID,
+			"blob": elem.Blob,
+		}).Warnf("Couldn't remove blob: %s", err.Error())
+	}
+}
@@ -0,0 +1,131 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// rebalanceTolerance returns how far above its target share a broker may be before
+// the load balancer moves keys off it, e.g. 0.2 for 20%
+func rebalanceTolerance() float64 {
+	if !viper.IsSet("rebalance_tolerance") {
+		return 0.2
+	}
+	return viper.GetFloat64("rebalance_tolerance")
+}
+
+// distribution returns the keys and the queued bytes of every broker that can hold
+// keys, next to its target share of them in proportion to its weight
+func (s *Zookeeper) distribution() ([]types.BrokerDistribution, error) {
+	candidates, err := s.placementCandidates()
+	if err != nil {
+		return nil, err
+	}
+	return distributionOf(candidates), nil
+}
+
+// distributionOf returns the keys and the queued bytes of the candidates next to their
+// target share of the total, in proportion to their weight
+func distributionOf(candidates []PlacementCandidate) []types.BrokerDistribution {
+	totalWeight, totalKeys, totalBytes := 0.0, 0, int64(0)
+	for _, c := range candidates {
+		totalWeight += brokerWeight(c.Broker)
+		totalKeys += c.Keys
+		totalBytes += c.Bytes
+	}
+
+	res := []types.BrokerDistribution{}
+	for _, c := range candidates {
+		share := brokerWeight(c.Broker) / totalWeight
+		res = append(res, types.BrokerDistribution{
+			Name:        c.Broker.Name,
+			Weight:      brokerWeight(c.Broker),
+			Keys:        c.Keys,
+			TargetKeys:  share * float64(totalKeys),
+			Bytes:       c.Bytes,
+			TargetBytes: share * float64(totalBytes),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// loadRatio returns how loaded a broker is compared to its target share: the largest of
+// its keys and its queued bytes over their targets
+func loadRatio(d types.BrokerDistribution) float64 {
+	ratio := 0.0
+	if d.TargetKeys > 0 {
+		ratio = math.Max(ratio, float64(d.Keys)/d.TargetKeys)
+	}
+	if d.TargetBytes > 0 {
+		ratio = math.Max(ratio, float64(d.Bytes)/d.TargetBytes)
+	}
+	return ratio
+}
+
+// rebalance moves a key from the broker most above its target share to the broker
+// most below it, if the first one is above it by more than the tolerance. It reports
+// whether a key was moved.
+func (s *Zookeeper) rebalance() bool {
+	dist, err := s.distribution()
+	if err != nil {
+		return false
+	}
+	over, under, ok := rebalancePair(dist, rebalanceTolerance())
+	if !ok {
+		return false
+	}
+
+	source, target := s.brokers[over.Name], s.brokers[under.Name]
+	key, isMaster := s.GetRandomKey(source, target)
+	if key == "" {
+		return false
+	}
+	log.WithFields(log.Fields{
+		"key":    key,
+		"source": source.Name,
+		"target": target.Name,
+		"load":   loadRatio(*over),
+	}).Info("Moving key to rebalance brokers by weight")
+	return s.moveKey(key, isMaster, source, target) == nil
+}
+
+// rebalancePair returns the broker most above its target share and the broker most
+// below it if moving a key between them brings the distribution closer to the targets:
+// the first one must be above its target by more than the tolerance, the second one
+// below it, and the move must not just swap which broker is above its target.
+func rebalancePair(dist []types.BrokerDistribution, tolerance float64) (*types.BrokerDistribution, *types.BrokerDistribution, bool) {
+	if len(dist) < 2 {
+		return nil, nil, false
+	}
+	dist = append([]types.BrokerDistribution(nil), dist...)
+	sort.SliceStable(dist, func(i, j int) bool { return loadRatio(dist[i]) > loadRatio(dist[j]) })
+	over, under := dist[0], dist[len(dist)-1]
+	if loadRatio(over) <= 1+tolerance || loadRatio(under) >= 1 {
+		return nil, nil, false
+	}
+	keysHelp := float64(over.Keys)-over.TargetKeys >= 1 && under.TargetKeys-float64(under.Keys) >= 1
+	bytesHelp := over.Keys > 1 && float64(over.Bytes) > over.TargetBytes*(1+tolerance) &&
+		float64(under.Bytes) < under.TargetBytes
+	if !keysHelp && !bytesHelp {
+		return nil, nil, false
+	}
+	return &over, &under, true
+}
+
+// GetDistribution returns the keys and the queued bytes of every broker with its
+// target share of them
+func (s *Zookeeper) GetDistribution(c *gin.Context) {
+	dist, err := s.distribution()
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.DistributionResponse{Brokers: dist})
+}
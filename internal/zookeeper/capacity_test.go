@@ -0,0 +1,84 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"testing"
+	"time"
+)
+
+// fakeDistribution returns the distribution of brokers named broker1, broker2, ...
+// with the given weights, key counts and queued bytes
+func fakeDistribution(weights []float64, keys []int, bytes []int64) []types.BrokerDistribution {
+	candidates := fakeCandidates(make([]time.Duration, len(weights)), keys, bytes)
+	for i, c := range candidates {
+		c.Broker.Weight = weights[i]
+	}
+	return distributionOf(candidates)
+}
+
+func TestLoadRatio(t *testing.T) {
+	tests := []struct {
+		d    types.BrokerDistribution
+		want float64
+	}{
+		{types.BrokerDistribution{}, 0},
+		{types.BrokerDistribution{Keys: 4, TargetKeys: 2}, 2},
+		{types.BrokerDistribution{Keys: 1, TargetKeys: 2, Bytes: 300, TargetBytes: 100}, 3},
+		{types.BrokerDistribution{Keys: 3, TargetKeys: 2, Bytes: 50, TargetBytes: 100}, 1.5},
+		{types.BrokerDistribution{Keys: 3, Bytes: 50}, 0},
+	}
+	for _, tt := range tests {
+		if got := loadRatio(tt.d); got != tt.want {
+			t.Errorf("loadRatio(%+v) = %g, want %g", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestDistributionTargets(t *testing.T) {
+	dist := fakeDistribution([]float64{3, 1}, []int{2, 6}, []int64{0, 400})
+	want := []types.BrokerDistribution{
+		{Name: "broker1", Weight: 3, Keys: 2, TargetKeys: 6, Bytes: 0, TargetBytes: 300},
+		{Name: "broker2", Weight: 1, Keys: 6, TargetKeys: 2, Bytes: 400, TargetBytes: 100},
+	}
+	for i := range want {
+		if dist[i] != want[i] {
+			t.Errorf("distribution[%d] = %+v, want %+v", i, dist[i], want[i])
+		}
+	}
+}
+
+func TestRebalancePair(t *testing.T) {
+	tests := []struct {
+		name      string
+		weights   []float64
+		keys      []int
+		bytes     []int64
+		wantOver  string
+		wantUnder string
+	}{
+		{name: "single broker", weights: []float64{1}, keys: []int{10}, bytes: []int64{0}},
+		{name: "balanced", weights: []float64{1, 1}, keys: []int{5, 5}, bytes: []int64{0, 0}},
+		{name: "within tolerance", weights: []float64{1, 1}, keys: []int{11, 9}, bytes: []int64{0, 0}},
+		{name: "too many keys", weights: []float64{1, 1, 1}, keys: []int{10, 3, 2}, bytes: []int64{0, 0, 0},
+			wantOver: "broker1", wantUnder: "broker3"},
+		{name: "balanced by weight", weights: []float64{3, 1}, keys: []int{6, 2}, bytes: []int64{0, 0}},
+		{name: "too many keys for weight", weights: []float64{3, 1}, keys: []int{2, 6}, bytes: []int64{0, 0},
+			wantOver: "broker2", wantUnder: "broker1"},
+		// Moving one of 3 keys over 2 brokers would only swap which one is above
+		{name: "move would swap", weights: []float64{1, 1}, keys: []int{2, 1}, bytes: []int64{0, 0}},
+		{name: "too many bytes", weights: []float64{1, 1}, keys: []int{3, 2}, bytes: []int64{900, 100},
+			wantOver: "broker1", wantUnder: "broker2"},
+		// A single key can't be split, however large
+		{name: "one large key", weights: []float64{1, 1}, keys: []int{1, 1}, bytes: []int64{900, 100}},
+	}
+	for _, tt := range tests {
+		over, under, ok := rebalancePair(fakeDistribution(tt.weights, tt.keys, tt.bytes), 0.2)
+		if ok != (tt.wantOver != "") {
+			t.Errorf("%s: rebalancePair() moves = %v, want %v", tt.name, ok, !ok)
+			continue
+		}
+		if ok && (over.Name != tt.wantOver || under.Name != tt.wantUnder) {
+			t.Errorf("%s: rebalancePair() = %s, %s, want %s, %s", tt.name, over.Name, under.Name, tt.wantOver, tt.wantUnder)
+		}
+	}
+}
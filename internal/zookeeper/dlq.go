@@ -0,0 +1,196 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// deadLetterSuffix is appended to a key to name its dead-letter key
+const deadLetterSuffix = ".dlq"
+
+func deadLetterKey(key string) string {
+	return key + deadLetterSuffix
+}
+
+func isDeadLetterKey(key string) bool {
+	return strings.HasSuffix(key, deadLetterSuffix)
+}
+
+// servesDeadLetters reports whether a pop or a subscription for a key argument may
+// serve messages of dead-letter keys. Pops from any key or from a pattern skip them
+// unless the pattern ends with the dead-letter suffix, so dead-lettered messages never
+// reach the consumers of the source keys.
+func servesDeadLetters(key string) bool {
+	return isDeadLetterKey(key)
+}
+
+// shouldDeadLetter reports whether a message that was delivered attempts times
+// must be moved to the dead-letter key instead of being delivered again
+func shouldDeadLetter(key string, attempts int) bool {
+	maxAttempts := viper.GetInt("max_delivery_attempts")
+	if maxAttempts <= 0 || isDeadLetterKey(key) {
+		return false
+	}
+	return attempts > maxAttempts
+}
+
+// deadLetter moves a leased message to the dead-letter key of its key. The message
+// is pushed to the dead-letter key before its lease is removed and it is erased from
+// the replicas of the source key, so a failure never loses it.
+func (s *Zookeeper) deadLetter(d *delivery) error {
+	key := d.Element.Key
+	elem := *d.Element
+	elem.Key = deadLetterKey(key)
+
+	log.WithFields(log.Fields{
+		"key":      key,
+		"id":       d.ID,
+		"attempts": d.Attempts,
+	}).Warn("Moving message to dead-letter key")
+	if err := s.pushElement(&elem); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec("DELETE FROM leases WHERE id = $1", d.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+			"id":  d.ID,
+		}).Warnf("Couldn't delete lease from database: %s", err.Error())
+		return err
+	}
+	s.EraseMessage(key, d.ID)
+	return nil
+}
+
+// drainDeadLetters pops every message of the dead-letter key of key and calls fn with it.
+// Every message is erased from the replicas of the dead-letter key after fn succeeds.
+func (s *Zookeeper) drainDeadLetters(key string, fn func(elem *types.Element) error) (int, error) {
+	dlq := deadLetterKey(key)
+	master := s.GetMasterBroker(dlq)
+	if master == nil {
+		return 0, errKeyNotFound
+	}
+
+	count := 0
+	for {
+		res, err := master.Pop(dlq)
+		if err != nil {
+			return count, err
+		}
+		if res.Key == "" {
+			return count, nil
+		}
+		if err := fn(res); err != nil {
+			return count, err
+		}
+		s.EraseMessage(dlq, res.ID)
+		count++
+	}
+}
+
+// deadLetters returns the messages in the dead-letter key of a key, with the values
+// stored as blobs loaded
+func (s *Zookeeper) deadLetters(key string) ([]types.Element, error) {
+	dlq := deadLetterKey(key)
+	master := s.GetMasterBroker(dlq)
+	if master == nil {
+		return []types.Element{}, nil
+	}
+
+	res, err := master.Export(dlq)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key":    dlq,
+			"broker": master.Name,
+		}).Warnf("Couldn't export dead-letter key: %s", err.Error())
+		return nil, err
+	}
+	for i := range res.Messages {
+		if err := loadValue(&res.Messages[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res.Messages, nil
+}
+
+// redriveDeadLetters moves the messages in the dead-letter key of a key back to the
+// key and returns how many were moved
+func (s *Zookeeper) redriveDeadLetters(key string) (int, error) {
+	count, err := s.drainDeadLetters(key, func(elem *types.Element) error {
+		elem.Key = key
+		return s.pushElement(elem)
+	})
+	if err != nil && !errors.Is(err, errKeyNotFound) {
+		log.WithFields(log.Fields{
+			"key":   key,
+			"count": count,
+		}).Warnf("Couldn't redrive dead-letter key: %s", err.Error())
+		return count, err
+	}
+
+	log.WithFields(log.Fields{
+		"key":   key,
+		"count": count,
+	}).Info("Redrove dead-letter key")
+	return count, nil
+}
+
+// purgeDeadLetters removes every message in the dead-letter key of a key and returns
+// how many were removed
+func (s *Zookeeper) purgeDeadLetters(key string) (int, error) {
+	count, err := s.drainDeadLetters(key, func(elem *types.Element) error {
+		removeBlob(elem)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errKeyNotFound) {
+		log.WithFields(log.Fields{
+			"key":   key,
+			"count": count,
+		}).Warnf("Couldn't purge dead-letter key: %s", err.Error())
+		return count, err
+	}
+
+	log.WithFields(log.Fields{
+		"key":   key,
+		"count": count,
+	}).Info("Purged dead-letter key")
+	return count, nil
+}
+
+// ListDeadLetters lists the messages in the dead-letter key of a key
+func (s *Zookeeper) ListDeadLetters(c *gin.Context) {
+	key := c.Param("key")
+	messages, err := s.deadLetters(key)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.DeadLettersResponse{Key: deadLetterKey(key), Messages: messages})
+}
+
+// RedriveDeadLetters moves the messages in the dead-letter key of a key back to the key
+func (s *Zookeeper) RedriveDeadLetters(c *gin.Context) {
+	count, err := s.redriveDeadLetters(c.Param("key"))
+	if err != nil {
+		failWith(c, err, map[string]interface{}{"count": count})
+		return
+	}
+	respond(c, http.StatusOK, &types.CountResponse{Message: "ok", Count: count})
+}
+
+// PurgeDeadLetters removes every message in the dead-letter key of a key
+func (s *Zookeeper) PurgeDeadLetters(c *gin.Context) {
+	count, err := s.purgeDeadLetters(c.Param("key"))
+	if err != nil {
+		failWith(c, err, map[string]interface{}{"count": count})
+		return
+	}
+	respond(c, http.StatusOK, &types.CountResponse{Message: "ok", Count: count})
+}
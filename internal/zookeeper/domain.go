@@ -0,0 +1,181 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"Zookeeper/internal/types"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// failureDomains returns the broker labels naming failure domains, from the widest to
+// the narrowest, e.g. zone then rack
+func failureDomains() []string {
+	return viper.GetStringSlice("failure_domains")
+}
+
+// domainPath returns the failure domain of the broker at every level. A level includes
+// the wider ones, so rack r1 of zone a and rack r1 of zone b are different racks.
+func domainPath(b *broker.Client, domains []string) []string {
+	res := make([]string, len(domains))
+	var path []string
+	for i, label := range domains {
+		path = append(path, b.Labels[label])
+		res[i] = strings.Join(path, "/")
+	}
+	return res
+}
+
+// domainOverlap returns the number of failure domain levels at which b shares a domain
+// with one of the other brokers. Sharing a narrow domain implies sharing the wider
+// ones, so a lower overlap means a better spread.
+func domainOverlap(b *broker.Client, others []*broker.Client, domains []string) int {
+	path := domainPath(b, domains)
+	overlap := 0
+	for i := range domains {
+		for _, o := range others {
+			if o != b && domainPath(o, domains)[i] == path[i] {
+				overlap++
+				break
+			}
+		}
+	}
+	return overlap
+}
+
+// spreadBrokers picks up to count of the ranked candidates. Every pick is the best
+// ranked candidate sharing the fewest failure domains with the brokers picked before
+// and the taken ones, so the copies of a key land in distinct domains whenever there
+// are enough of them.
+func spreadBrokers(ranked []PlacementCandidate, count int, taken []*broker.Client, domains []string) []PlacementCandidate {
+	left := append([]PlacementCandidate(nil), ranked...)
+	others := append([]*broker.Client(nil), taken...)
+	var res []PlacementCandidate
+	for len(res) < count && len(left) > 0 {
+		pick := 0
+		best := domainOverlap(left[0].Broker, others, domains)
+		for i := 1; i < len(left) && best > 0; i++ {
+			if overlap := domainOverlap(left[i].Broker, others, domains); overlap < best {
+				pick, best = i, overlap
+			}
+		}
+		res = append(res, left[pick])
+		others = append(others, left[pick].Broker)
+		left = append(left[:pick], left[pick+1:]...)
+	}
+	return res
+}
+
+// keepsSpread reports whether moving the copy of a key held by source to target leaves
+// the copies of the key spread over at least as many failure domains
+func (s *Zookeeper) keepsSpread(k types.KeyAssignment, source, target *broker.Client) bool {
+	var others []*broker.Client
+	for _, name := range append([]string{k.Master}, k.Replicas...) {
+		if b := s.brokers[name]; b != nil && b != source {
+			others = append(others, b)
+		}
+	}
+	domains := failureDomains()
+	return domainOverlap(target, others, domains) <= domainOverlap(source, others, domains)
+}
+
+// replacementBroker returns the broker that should take a copy of the key next to the
+// brokers keeping one, chosen by the placement strategy among the healthy brokers
+// that don't hold the key and spread across failure domains. It returns nil if no
+// broker can take it.
+func (s *Zookeeper) replacementBroker(key string, keep []string, holders []string) *broker.Client {
+	candidates, err := s.placementCandidates()
+	if err != nil {
+		return nil
+	}
+	excluded := map[string]bool{}
+	for _, name := range holders {
+		excluded[name] = true
+	}
+	var eligible []PlacementCandidate
+	for _, c := range candidates {
+		if !excluded[c.Broker.Name] {
+			eligible = append(eligible, c)
+		}
+	}
+	var taken []*broker.Client
+	for _, name := range keep {
+		if b := s.brokers[name]; b != nil {
+			taken = append(taken, b)
+		}
+	}
+
+	picked := spreadBrokers(s.placement.Rank(key, eligible), 1, taken, failureDomains())
+	if len(picked) == 0 {
+		return nil
+	}
+	return picked[0].Broker
+}
+
+// replaceCopy gives the copy of the key lost with a failed broker to another broker,
+// copying the messages of the master of the key
+func (s *Zookeeper) replaceCopy(k types.KeyAssignment, lost *broker.Client) error {
+	holders := append([]string{k.Master}, k.Replicas...)
+	var keep []string
+	for _, name := range holders {
+		if name != lost.Name {
+			keep = append(keep, name)
+		}
+	}
+	target := s.replacementBroker(k.Key, keep, holders)
+	if target == nil {
+		log.WithFields(log.Fields{
+			"key":    k.Key,
+			"broker": lost.Name,
+		}).Warn("No broker can take over the lost copy of the key")
+		return nil
+	}
+	master := s.GetMasterBroker(k.Key)
+	if master == nil {
+		return errKeyNotFound
+	}
+
+	log.WithFields(log.Fields{
+		"key":    k.Key,
+		"lost":   lost.Name,
+		"broker": target.Name,
+	}).Info("Replacing lost copy of key")
+	if err := s.copyKey(k.Key, master, target); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT INTO queues (queue, broker, is_master) VALUES ($1, $2, False)", k.Key, target.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key":    k.Key,
+			"broker": target.Name,
+		}).Warnf("Couldn't add key to database: %s", err.Error())
+		return err
+	}
+	return s.pinRoute(k.Key)
+}
+
+// copyKey imports the messages of the key held by source into target as a replica
+func (s *Zookeeper) copyKey(key string, source, target *broker.Client) error {
+	source.Mutex.Lock()
+	target.Mutex.Lock()
+	defer source.Mutex.Unlock()
+	defer target.Mutex.Unlock()
+
+	keyData, err := source.Export(key)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"broker": source.Name,
+			"key":    key,
+		}).Errorf("Couldn't export key: %s", err.Error())
+		return err
+	}
+	if err := target.Import(key, false, keyData.Messages); err != nil {
+		log.WithFields(log.Fields{
+			"broker": target.Name,
+			"key":    key,
+		}).Errorf("Couldn't import key: %s", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"errors"
+	"net/http"
+)
+
+// validationError is an error caused by an invalid request
+type validationError struct {
+	err error
+}
+
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *validationError) Unwrap() error {
+	return e.err
+}
+
+// invalid marks an error as caused by an invalid request
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &validationError{err: err}
+}
+
+// httpStatus returns the HTTP status code matching an error
+func httpStatus(err error) int {
+	var verr *validationError
+	switch {
+	case errors.As(err, &verr):
+		return http.StatusBadRequest
+	case errors.Is(err, errKeyNotFound), errors.Is(err, errLeaseNotFound), errors.Is(err, errBrokerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errPushInProgress):
+		return http.StatusConflict
+	case errors.Is(err, errTxAborted), errors.Is(err, errNoBrokers):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errMessageTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCode returns the code of the error envelope matching an error
+func errorCode(err error) string {
+	var verr *validationError
+	switch {
+	case errors.As(err, &verr):
+		return types.ErrorInvalidRequest
+	case errors.Is(err, errKeyNotFound):
+		return types.ErrorKeyNotFound
+	case errors.Is(err, errLeaseNotFound):
+		return types.ErrorLeaseNotFound
+	case errors.Is(err, errBrokerNotFound):
+		return types.ErrorBrokerNotFound
+	case errors.Is(err, errQueueFull):
+		return types.ErrorQueueFull
+	case errors.Is(err, errPushInProgress):
+		return types.ErrorPushInProgress
+	case errors.Is(err, errTxAborted):
+		return types.ErrorTxAborted
+	case errors.Is(err, errMessageTooLarge):
+		return types.ErrorTooLarge
+	case errors.Is(err, errNoBrokers):
+		return types.ErrorNoBrokers
+	default:
+		return types.ErrorInternal
+	}
+}
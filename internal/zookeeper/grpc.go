@@ -0,0 +1,360 @@
+package zookeeper
+
+import (
+	"Zookeeper/api/zookeeperpb"
+	"Zookeeper/internal/types"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// produceBatchSize is the number of streamed messages Produce pushes as one batch
+const produceBatchSize = 100
+
+// grpcServer serves the gRPC API on top of the same core as the HTTP handlers
+type grpcServer struct {
+	zookeeperpb.UnimplementedZookeeperServer
+	s *Zookeeper
+}
+
+func (s *Zookeeper) registerGRPC() {
+	s.grpc = grpc.NewServer()
+	zookeeperpb.RegisterZookeeperServer(s.grpc, &grpcServer{s: s})
+}
+
+// runGRPC serves the gRPC API on the grpc_port
+func (s *Zookeeper) runGRPC(port string) {
+	lis, err := net.Listen("tcp", "0.0.0.0:"+port)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	log.WithFields(log.Fields{
+		"port": port,
+	}).Info("Serving gRPC API")
+	if err := s.grpc.Serve(lis); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// grpcError converts an error of the core to a gRPC status error
+func grpcError(err error) error {
+	var code codes.Code
+	switch httpStatus(err) {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests, http.StatusRequestEntityTooLarge:
+		code = codes.ResourceExhausted
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func (g *grpcServer) Push(ctx context.Context, req *zookeeperpb.PushRequest) (*zookeeperpb.PushResult, error) {
+	pushRequest, err := fromPushRequest(req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	result, err := g.s.push(pushRequest)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toPushResult(result), nil
+}
+
+func (g *grpcServer) PushBatch(ctx context.Context, req *zookeeperpb.PushBatchRequest) (*zookeeperpb.PushBatchResponse, error) {
+	return toPushBatchResponse(g.pushMessages(req.Messages)), nil
+}
+
+// pushMessages pushes messages as a batch. A message that can't be converted gets a
+// failed result at its index, like a message the brokers rejected.
+func (g *grpcServer) pushMessages(messages []*zookeeperpb.PushRequest) []types.PushResult {
+	results := make([]types.PushResult, len(messages))
+	reqs := make([]types.PushRequest, 0, len(messages))
+	indices := make([]int, 0, len(messages))
+	for index, message := range messages {
+		pushRequest, err := fromPushRequest(message)
+		if err != nil {
+			results[index] = types.PushResult{Key: message.GetKey(), Status: types.StatusFailed, Error: err.Error()}
+			continue
+		}
+		reqs = append(reqs, *pushRequest)
+		indices = append(indices, index)
+	}
+	if len(reqs) == 0 {
+		return results
+	}
+	for i, result := range g.s.pushBatch(reqs) {
+		results[indices[i]] = result
+	}
+	return results
+}
+
+func (g *grpcServer) PushTransaction(ctx context.Context, req *zookeeperpb.PushBatchRequest) (*zookeeperpb.PushTransactionResponse, error) {
+	reqs := make([]types.PushRequest, 0, len(req.Messages))
+	for _, message := range req.Messages {
+		pushRequest, err := fromPushRequest(message)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		reqs = append(reqs, *pushRequest)
+	}
+	id, results, err := g.s.pushTransaction(reqs)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &zookeeperpb.PushTransactionResponse{Id: id, Results: toPushBatchResponse(results).Results}, nil
+}
+
+func (g *grpcServer) Produce(stream zookeeperpb.Zookeeper_ProduceServer) error {
+	var results []types.PushResult
+	var messages []*zookeeperpb.PushRequest
+	for {
+		message, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message)
+		if len(messages) == produceBatchSize {
+			results = append(results, g.pushMessages(messages)...)
+			messages = nil
+		}
+	}
+	if len(messages) > 0 {
+		results = append(results, g.pushMessages(messages)...)
+	}
+	return stream.SendAndClose(toPushBatchResponse(results))
+}
+
+func (g *grpcServer) Pop(ctx context.Context, req *zookeeperpb.PopRequest) (*zookeeperpb.PopResponse, error) {
+	d, err := g.s.popWait(ctx, req.Key, req.Wait.AsDuration(), g.s.popper(req.Key))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if d == nil {
+		return &zookeeperpb.PopResponse{}, nil
+	}
+	if err := loadValue(d.Element); err != nil {
+		return nil, grpcError(err)
+	}
+	return &zookeeperpb.PopResponse{Delivery: toDelivery(d)}, nil
+}
+
+func (g *grpcServer) Consume(req *zookeeperpb.ConsumeRequest, stream zookeeperpb.Zookeeper_ConsumeServer) error {
+	prefetch := int(req.Prefetch)
+	if prefetch <= 0 {
+		prefetch = viper.GetInt("subscribe_prefetch")
+	}
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+
+	err := g.s.consume(stream.Context(), req.Keys, prefetch, func(d *delivery, err error) error {
+		if err != nil {
+			log.WithFields(log.Fields{
+				"keys": req.Keys,
+			}).Warnf("Couldn't pop message for consumer: %s", err.Error())
+			return nil
+		}
+		if err := loadValue(d.Element); err != nil {
+			log.WithFields(log.Fields{
+				"key": d.Element.Key,
+				"id":  d.ID,
+			}).Warnf("Couldn't load message value for consumer: %s", err.Error())
+			return nil
+		}
+		return stream.Send(toDelivery(d))
+	}, func() {})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func (g *grpcServer) Ack(ctx context.Context, req *zookeeperpb.AckRequest) (*zookeeperpb.AckResponse, error) {
+	if err := g.s.ack(req.Id); err != nil {
+		return nil, grpcError(err)
+	}
+	return &zookeeperpb.AckResponse{}, nil
+}
+
+func (g *grpcServer) Nack(ctx context.Context, req *zookeeperpb.NackRequest) (*zookeeperpb.NackResponse, error) {
+	deadLettered, err := g.s.nack(req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &zookeeperpb.NackResponse{DeadLettered: deadLettered}, nil
+}
+
+func (g *grpcServer) GetKeySettings(ctx context.Context, req *zookeeperpb.KeyRequest) (*zookeeperpb.KeySettingsResponse, error) {
+	settings, err := g.s.keySettings(req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	size, err := g.s.keySize(req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &zookeeperpb.KeySettingsResponse{
+		Key:      req.Key,
+		Settings: toKeySettings(settings),
+		Size:     int64(size.Size),
+		Bytes:    size.Bytes,
+	}, nil
+}
+
+func (g *grpcServer) SetKeySettings(ctx context.Context, req *zookeeperpb.SetKeySettingsRequest) (*zookeeperpb.KeySettingsResponse, error) {
+	settings := &types.KeySettings{}
+	if req.Settings != nil {
+		settings.MaxLength = int(req.Settings.MaxLength)
+		settings.OverflowPolicy = req.Settings.OverflowPolicy
+		settings.OverflowKey = req.Settings.OverflowKey
+		settings.Weight = int(req.Settings.Weight)
+		if req.Settings.DefaultTtl != nil {
+			settings.DefaultTTL = req.Settings.DefaultTtl.AsDuration().String()
+		}
+	}
+	if err := g.s.setKeySettings(req.Key, settings); err != nil {
+		return nil, grpcError(err)
+	}
+	return g.GetKeySettings(ctx, &zookeeperpb.KeyRequest{Key: req.Key})
+}
+
+func (g *grpcServer) ListDeadLetters(ctx context.Context, req *zookeeperpb.KeyRequest) (*zookeeperpb.DeadLettersResponse, error) {
+	messages, err := g.s.deadLetters(req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &zookeeperpb.DeadLettersResponse{Key: deadLetterKey(req.Key)}
+	for i := range messages {
+		res.Messages = append(res.Messages, toMessage(&messages[i]))
+	}
+	return res, nil
+}
+
+func (g *grpcServer) RedriveDeadLetters(ctx context.Context, req *zookeeperpb.KeyRequest) (*zookeeperpb.CountResponse, error) {
+	count, err := g.s.redriveDeadLetters(req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &zookeeperpb.CountResponse{Count: int64(count)}, nil
+}
+
+func (g *grpcServer) PurgeDeadLetters(ctx context.Context, req *zookeeperpb.KeyRequest) (*zookeeperpb.CountResponse, error) {
+	count, err := g.s.purgeDeadLetters(req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &zookeeperpb.CountResponse{Count: int64(count)}, nil
+}
+
+func fromPushRequest(req *zookeeperpb.PushRequest) (*types.PushRequest, error) {
+	if req.Key == "" {
+		return nil, invalid(errors.New("key is required"))
+	}
+	if len(req.Value) == 0 {
+		return nil, invalid(errors.New("value is required"))
+	}
+	res := &types.PushRequest{
+		Key:            req.Key,
+		Value:          req.Value,
+		Headers:        req.Headers,
+		Priority:       int(req.Priority),
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	if req.Ttl != nil {
+		res.TTL = req.Ttl.AsDuration().String()
+	}
+	if req.Delay != nil {
+		res.Delay = req.Delay.AsDuration().String()
+	}
+	if req.DeliverAt != nil {
+		res.DeliverAt = timeOf(req.DeliverAt)
+	}
+	return res, nil
+}
+
+func toPushResult(result *types.PushResult) *zookeeperpb.PushResult {
+	return &zookeeperpb.PushResult{
+		Id:        result.ID,
+		Key:       result.Key,
+		Status:    result.Status,
+		Error:     result.Error,
+		DeliverAt: timestampOf(result.DeliverAt),
+	}
+}
+
+func toPushBatchResponse(results []types.PushResult) *zookeeperpb.PushBatchResponse {
+	res := &zookeeperpb.PushBatchResponse{}
+	for i := range results {
+		res.Results = append(res.Results, toPushResult(&results[i]))
+	}
+	return res
+}
+
+func toMessage(elem *types.Element) *zookeeperpb.Message {
+	return &zookeeperpb.Message{
+		Id:        elem.ID,
+		Key:       elem.Key,
+		Value:     elem.Value,
+		Timestamp: timestamppb.New(elem.Timestamp),
+		Headers:   elem.Headers,
+		ExpiresAt: timestampOf(elem.ExpiresAt),
+		DeliverAt: timestampOf(elem.DeliverAt),
+		Priority:  int32(elem.Priority),
+		Seq:       elem.Seq,
+	}
+}
+
+func toDelivery(d *delivery) *zookeeperpb.Delivery {
+	return &zookeeperpb.Delivery{
+		Message:  toMessage(d.Element),
+		Deadline: timestamppb.New(d.Deadline),
+		Attempts: int32(d.Attempts),
+	}
+}
+
+func toKeySettings(settings *types.KeySettings) *zookeeperpb.KeySettings {
+	res := &zookeeperpb.KeySettings{
+		MaxLength:      int32(settings.MaxLength),
+		OverflowPolicy: settings.OverflowPolicy,
+		OverflowKey:    settings.OverflowKey,
+		Weight:         int32(settings.Weight),
+	}
+	if d, err := time.ParseDuration(settings.DefaultTTL); err == nil {
+		res.DefaultTtl = durationpb.New(d)
+	}
+	return res
+}
+
+func timestampOf(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func timeOf(ts *timestamppb.Timestamp) *time.Time {
+	t := ts.AsTime()
+	return &t
+}
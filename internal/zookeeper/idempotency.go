@@ -0,0 +1,135 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var errPushInProgress = errors.New("a push with the same idempotency key is in progress")
+
+func dedupWindow() time.Duration {
+	d := viper.GetDuration("dedup_window")
+	if d <= 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+// claimTimeout is how long a push may hold an idempotency key without storing its
+// result before another push takes the key over, in case its zookeeper stopped
+func claimTimeout() time.Duration {
+	d := viper.GetDuration("idempotency_claim_timeout")
+	if d <= 0 {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// beginIdempotentPush claims an idempotency key for a push. If the key was already
+// used inside the deduplication window, the result of the original push is returned
+// and nothing must be written to the brokers. The keys are stored in the database,
+// so the window holds across every zookeeper sharing it. A key claimed by a push that
+// didn't finish within the claim timeout is taken over. The returned claim identifies
+// the push holding the key when it finishes.
+func (s *Zookeeper) beginIdempotentPush(key string) (*types.PushResult, string, error) {
+	window := dedupWindow().Seconds()
+	_, err := s.db.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND created_at < now() - make_interval(secs => $2)", key, window)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"idempotency_key": key,
+		}).Warnf("Couldn't delete expired idempotency key: %s", err.Error())
+		return nil, "", err
+	}
+
+	claim := newID()
+	res, err := s.db.Exec(`INSERT INTO idempotency_keys (key, claim) VALUES ($1, $3)
+		ON CONFLICT (key) DO UPDATE SET claimed_at = now(), claim = $3
+		WHERE idempotency_keys.response IS NULL AND idempotency_keys.claimed_at < now() - make_interval(secs => $2)`,
+		key, claimTimeout().Seconds(), claim)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"idempotency_key": key,
+		}).Warnf("Couldn't store idempotency key: %s", err.Error())
+		return nil, "", err
+	}
+	if inserted, err := res.RowsAffected(); err != nil || inserted == 1 {
+		return nil, claim, err
+	}
+
+	var data []byte
+	err = s.db.QueryRow("SELECT response FROM idempotency_keys WHERE key = $1", key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.beginIdempotentPush(key)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if data == nil {
+		return nil, "", errPushInProgress
+	}
+
+	result := &types.PushResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, "", err
+	}
+	log.WithFields(log.Fields{
+		"idempotency_key": key,
+		"id":              result.ID,
+	}).Info("Duplicate push ignored")
+	return result, "", nil
+}
+
+// finishIdempotentPush stores the result of a successful push for its idempotency key,
+// or releases the key after a failed push so that it can be retried. Nothing is
+// written if another push took the key over after the claim timed out.
+func (s *Zookeeper) finishIdempotentPush(key, claim string, result *types.PushResult) {
+	var res sql.Result
+	var err error
+	if result.Status == types.StatusOK {
+		var data []byte
+		data, err = json.Marshal(result)
+		if err == nil {
+			res, err = s.db.Exec("UPDATE idempotency_keys SET response = $3 WHERE key = $1 AND claim = $2", key, claim, string(data))
+		}
+	} else {
+		res, err = s.db.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND claim = $2", key, claim)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"idempotency_key": key,
+		}).Warnf("Couldn't finish idempotency key: %s", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.WithFields(log.Fields{
+			"idempotency_key": key,
+		}).Warn("Idempotency key was taken over by another push after its claim timed out")
+	}
+}
+
+// IdempotencyKeyCleaner periodically removes the idempotency keys older than the
+// deduplication window
+func (s *Zookeeper) IdempotencyKeyCleaner() {
+	ticker := time.NewTicker(dedupWindow())
+
+	for {
+		select {
+		case <-ticker.C:
+			res, err := s.db.Exec("DELETE FROM idempotency_keys WHERE created_at < now() - make_interval(secs => $1)", dedupWindow().Seconds())
+			if err != nil {
+				log.Warnf("Couldn't delete expired idempotency keys: %s", err.Error())
+				continue
+			}
+			count, _ := res.RowsAffected()
+			log.WithFields(log.Fields{
+				"count": count,
+			}).Debug("Deleted expired idempotency keys")
+		}
+	}
+}
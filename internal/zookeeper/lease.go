@@ -0,0 +1,236 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var errLeaseNotFound = errors.New("lease not found")
+
+// delivery is a message handed to a consumer under a lease. The message stays on the
+// replicas of its key until the lease is acknowledged.
+type delivery struct {
+	ID       string
+	Element  *types.Element
+	Deadline time.Time
+	Attempts int
+}
+
+func leaseTimeout() time.Duration {
+	d := viper.GetDuration("lease_timeout")
+	if d <= 0 {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// lease stores a message at the front of a master broker in the leases table before
+// it is removed from the master, so a failure never loses it. The lease is identified
+// by the ID of the message. It returns nil if the message is already leased, which
+// happens when another pop raced for it or when a promoted replica still holds a
+// message leased before a failover.
+func (s *Zookeeper) lease(elem *types.Element) (*delivery, error) {
+	if elem.ID == "" {
+		elem.ID = newID()
+	}
+	data, err := json.Marshal(elem)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &delivery{
+		ID:       elem.ID,
+		Element:  elem,
+		Deadline: time.Now().Add(leaseTimeout()),
+		Attempts: 1,
+	}
+	res, err := s.db.Exec(`INSERT INTO leases (id, queue, message, priority, deadline, attempts) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO NOTHING`, d.ID, elem.Key, string(data), elem.Priority, d.Deadline, d.Attempts)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": elem.Key,
+			"id":  d.ID,
+		}).Errorf("Couldn't store lease in database: %s", err.Error())
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		log.WithFields(log.Fields{
+			"key": elem.Key,
+			"id":  d.ID,
+		}).Info("Message is already leased")
+		return nil, nil
+	}
+	return d, nil
+}
+
+// claimExpiredLease leases again a message whose lease expired or was nacked and whose
+// priority is at least minPriority, serving higher priorities first.
+// Messages whose TTL passed are dropped and messages delivered too many times are
+// moved to their dead-letter key instead.
+// An empty key claims a message of any key and a pattern a message of a matching key.
+// It returns nil if there is nothing to redeliver.
+func (s *Zookeeper) claimExpiredLease(key string, minPriority int) (*delivery, error) {
+	for {
+		d, err := s.claimLease(key, minPriority)
+		if d == nil || err != nil {
+			return d, err
+		}
+		if expired(d.Element) {
+			if err := s.dropLease(d); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !shouldDeadLetter(d.Element.Key, d.Attempts) {
+			return d, nil
+		}
+		if err := s.deadLetter(d); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *Zookeeper) claimLease(key string, minPriority int) (*delivery, error) {
+	d := &delivery{}
+	var data []byte
+	err := s.db.QueryRow(`UPDATE leases SET deadline = $2, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM leases WHERE queue LIKE $1 ESCAPE '\' AND deadline < now()
+			AND ($3 OR queue NOT LIKE $4) AND priority >= $5
+			ORDER BY priority DESC, created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+		) RETURNING id, message, deadline, attempts`, likePattern(key), time.Now().Add(leaseTimeout()),
+		servesDeadLetters(key), likePattern("*"+deadLetterSuffix), minPriority).Scan(&d.ID, &data, &d.Deadline, &d.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't claim expired lease: %s", err.Error())
+		return nil, err
+	}
+
+	d.Element = &types.Element{}
+	if err := json.Unmarshal(data, d.Element); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"key":      d.Element.Key,
+		"id":       d.ID,
+		"attempts": d.Attempts,
+	}).Info("Redelivering message")
+	return d, nil
+}
+
+// dropLease removes the lease of a message whose TTL passed before it was redelivered
+func (s *Zookeeper) dropLease(d *delivery) error {
+	_, err := s.db.Exec("DELETE FROM leases WHERE id = $1", d.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": d.Element.Key,
+			"id":  d.ID,
+		}).Warnf("Couldn't delete lease from database: %s", err.Error())
+		return err
+	}
+	s.discardExpired(d.Element)
+	return nil
+}
+
+// ack acknowledges a leased message and erases it from the replicas of its key
+func (s *Zookeeper) ack(id string) error {
+	var key string
+	var data []byte
+	err := s.db.QueryRow("DELETE FROM leases WHERE id = $1 RETURNING queue, message", id).Scan(&key, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errLeaseNotFound
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id": id,
+		}).Warnf("Couldn't delete lease from database: %s", err.Error())
+		return err
+	}
+
+	s.EraseMessage(key, id)
+	s.acks.Notify(id)
+	elem := &types.Element{}
+	if err := json.Unmarshal(data, elem); err == nil {
+		removeBlob(elem)
+	}
+	log.WithFields(log.Fields{
+		"key": key,
+		"id":  id,
+	}).Info("Acknowledged message")
+	return nil
+}
+
+// nack releases a leased message so it is redelivered by the next pop, or moves it to
+// the dead-letter key of its key once it failed too many times. It reports whether
+// the message was dead-lettered.
+func (s *Zookeeper) nack(id string) (bool, error) {
+	d := &delivery{ID: id}
+	var data []byte
+	err := s.db.QueryRow("UPDATE leases SET deadline = now() WHERE id = $1 RETURNING message, attempts", id).Scan(&data, &d.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errLeaseNotFound
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id": id,
+		}).Warnf("Couldn't release lease in database: %s", err.Error())
+		return false, err
+	}
+
+	d.Element = &types.Element{}
+	if err := json.Unmarshal(data, d.Element); err != nil {
+		return false, err
+	}
+	key := d.Element.Key
+	s.acks.Notify(id)
+
+	if shouldDeadLetter(key, d.Attempts+1) {
+		if err := s.deadLetter(d); err != nil {
+			log.WithFields(log.Fields{
+				"key": key,
+				"id":  id,
+			}).Warnf("Couldn't move message to dead-letter key: %s", err.Error())
+			return false, err
+		}
+		return true, nil
+	}
+
+	s.notifier.Notify(key)
+	log.WithFields(log.Fields{
+		"key": key,
+		"id":  id,
+	}).Info("Released message for redelivery")
+	return false, nil
+}
+
+// Ack acknowledges a leased message and erases it from the replicas of its key
+func (s *Zookeeper) Ack(c *gin.Context) {
+	if err := s.ack(c.Param("id")); err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.OKResponse{Message: "ok"})
+}
+
+// Nack releases a leased message so it is redelivered by the next pop, or moves it to
+// the dead-letter key of its key once it failed too many times
+func (s *Zookeeper) Nack(c *gin.Context) {
+	deadLettered, err := s.nack(c.Param("id"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.NackResponse{Message: "ok", DeadLettered: deadLettered})
+}
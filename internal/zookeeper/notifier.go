@@ -0,0 +1,73 @@
+package zookeeper
+
+import (
+	"Zookeeper/pkg/glob"
+	"sync"
+)
+
+// anyKey is the notifier key used by waiters that accept a message from any key
+const anyKey = ""
+
+// notifier wakes up pop requests waiting for a message to be pushed to a key
+type notifier struct {
+	mutex   sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Subscribe returns a channel which receives a signal whenever a message is pushed
+// to one of the keys, or to a key matching one of them if it is a pattern. Use anyKey
+// to get a signal for every pushed message.
+func (n *notifier) Subscribe(keys ...string) chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	ch := make(chan struct{}, 1)
+	for _, key := range keys {
+		if n.waiters[key] == nil {
+			n.waiters[key] = make(map[chan struct{}]struct{})
+		}
+		n.waiters[key][ch] = struct{}{}
+	}
+	return ch
+}
+
+// Unsubscribe removes a channel returned by Subscribe for the same keys
+func (n *notifier) Unsubscribe(ch chan struct{}, keys ...string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(n.waiters[key], ch)
+		if len(n.waiters[key]) == 0 {
+			delete(n.waiters, key)
+		}
+	}
+}
+
+// Notify wakes up the waiters of the key, of the patterns matching it and of any key.
+// Pushes to dead-letter keys only wake up the waiters that may be served them.
+func (n *notifier) Notify(key string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for k, waiters := range n.waiters {
+		if k != key && k != anyKey && !(glob.IsPattern(k) && glob.Match(k, key)) {
+			continue
+		}
+		if k != key && isDeadLetterKey(key) && !servesDeadLetters(k) {
+			continue
+		}
+		for ch := range waiters {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
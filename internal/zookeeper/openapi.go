@@ -0,0 +1,185 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPI builds the OpenAPI 3 document of the v1 API from the endpoints and the
+// types of their requests and responses
+func (s *Zookeeper) openAPI() gin.H {
+	schemas := gin.H{}
+	paths := gin.H{}
+
+	errorResponse := gin.H{
+		"description": "Error",
+		"content": gin.H{
+			"application/json": gin.H{"schema": schemaRef(reflect.TypeOf(types.ErrorResponse{}), schemas)},
+		},
+	}
+
+	for _, e := range s.endpoints() {
+		path := apiVersion + openAPIPath(e.path)
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+
+		parameters := []gin.H{}
+		for _, segment := range strings.Split(e.path, "/") {
+			if strings.HasPrefix(segment, ":") {
+				parameters = append(parameters, gin.H{
+					"name":     segment[1:],
+					"in":       "path",
+					"required": true,
+					"schema":   gin.H{"type": "string"},
+				})
+			}
+		}
+		for _, p := range e.parameters {
+			parameters = append(parameters, gin.H{
+				"name":        p.name,
+				"in":          p.in,
+				"description": p.description,
+				"schema":      gin.H{"type": "string"},
+			})
+		}
+
+		contentType := e.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		operation := gin.H{
+			"summary":    e.summary,
+			"parameters": parameters,
+			"responses": gin.H{
+				"200": gin.H{
+					"description": "OK",
+					"content": gin.H{
+						contentType: gin.H{"schema": schemaRef(reflect.TypeOf(e.response), schemas)},
+					},
+				},
+				"default": errorResponse,
+			},
+		}
+		if e.request != nil {
+			operation["requestBody"] = gin.H{
+				"required": true,
+				"content": gin.H{
+					"application/json": gin.H{"schema": schemaRef(reflect.TypeOf(e.request), schemas)},
+				},
+			}
+		}
+		if e.rawRequest {
+			operation["requestBody"] = gin.H{
+				"required": true,
+				"content": gin.H{
+					rawContentType: gin.H{"schema": gin.H{"type": "string", "format": "binary"}},
+				},
+			}
+		}
+		item[strings.ToLower(e.method)] = operation
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "Zookeeper",
+			"version": strings.TrimPrefix(apiVersion, "/"),
+		},
+		"paths":      paths,
+		"components": gin.H{"schemas": schemas},
+	}
+}
+
+// openAPIPath converts the parameters of a gin path, e.g. :key, to {key}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// schemaRef returns the schema of a type. Structs are added to schemas by name and
+// referenced.
+func schemaRef(t reflect.Type, schemas gin.H) gin.H {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return gin.H{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return gin.H{"type": "string", "format": "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": schemaRef(t.Elem(), schemas)}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": schemaRef(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = gin.H{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return gin.H{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return gin.H{}
+}
+
+// structSchema returns the object schema of a struct. Fields are named by their json
+// tag and required by their binding tag.
+func structSchema(t reflect.Type, schemas gin.H) gin.H {
+	properties := gin.H{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaRef(field.Type, schemas)
+		for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+			if rule == "required" {
+				required = append(required, name)
+			}
+		}
+	}
+
+	schema := gin.H{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// OpenAPI serves the OpenAPI document of the v1 API
+func (s *Zookeeper) OpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, s.openAPI())
+}
@@ -0,0 +1,27 @@
+package zookeeper
+
+import "strings"
+
+// likePattern converts a glob pattern to a LIKE pattern escaped with a backslash. A key
+// without wildcards becomes a pattern matching only itself; the empty key matches
+// every key.
+func likePattern(pattern string) string {
+	if pattern == anyKey {
+		return "%"
+	}
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package zookeeper
+
+import "testing"
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"", "%"},
+		{"orders", "orders"},
+		{"*", "%"},
+		{"orders.*", "orders.%"},
+		{"order?", "order_"},
+		{"*.dlq", "%.dlq"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`a\b`, `a\\b`},
+		{`a\*`, `a\\%`},
+		{"%_*?", `\%\_%_`},
+		{"ü?", "ü_"},
+	}
+	for _, tt := range tests {
+		if got := likePattern(tt.pattern); got != tt.want {
+			t.Errorf("likePattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestNotifierPatterns(t *testing.T) {
+	tests := []struct {
+		waiter string
+		key    string
+		want   bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "payments", false},
+		{anyKey, "orders", true},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "payments.eu", false},
+		{"orders.%", "orders.eu", false},
+		// Dead-letter keys only wake up the waiters that name them
+		{anyKey, "orders.dlq", false},
+		{"orders.*", "orders.dlq", false},
+		{"*.dlq", "orders.dlq", true},
+		{"orders.dlq", "orders.dlq", true},
+	}
+	for _, tt := range tests {
+		n := newNotifier()
+		ch := n.Subscribe(tt.waiter)
+		n.Notify(tt.key)
+		got := false
+		select {
+		case <-ch:
+			got = true
+		default:
+		}
+		n.Unsubscribe(ch, tt.waiter)
+		if got != tt.want {
+			t.Errorf("waiter %q notified of a push to %q = %v, want %v", tt.waiter, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Placement strategies choosing the brokers of a new key
+const (
+	PlacementLowestLatency  = "lowest_latency"
+	PlacementLeastKeys      = "least_keys"
+	PlacementLeastBytes     = "least_queued_bytes"
+	PlacementLatencyRandom  = "latency_weighted_random"
+	PlacementConsistentHash = "consistent_hash"
+)
+
+// PlacementCandidate is a broker that may hold a new key, with its current load
+type PlacementCandidate struct {
+	Broker *broker.Client
+	Keys   int
+	Bytes  int64
+}
+
+// keyLoad returns the number of keys of the candidate for its weight
+func (c PlacementCandidate) keyLoad() float64 {
+	return float64(c.Keys) / brokerWeight(c.Broker)
+}
+
+// byteLoad returns the queued bytes of the candidate for its weight
+func (c PlacementCandidate) byteLoad() float64 {
+	return float64(c.Bytes) / brokerWeight(c.Broker)
+}
+
+// PlacementStrategy ranks the candidate brokers of a new key, best first. The first
+// broker becomes the master of the key and the next ones its replicas.
+type PlacementStrategy interface {
+	Name() string
+	Rank(key string, candidates []PlacementCandidate) []PlacementCandidate
+}
+
+// newPlacementStrategy returns the strategy named by placement_strategy, lowest
+// latency by default. The consistent hashing strategy places the keys on a ring of
+// the given brokers, with points in proportion to their weights.
+func newPlacementStrategy(name string, brokers []string, weights map[string]float64) (PlacementStrategy, error) {
+	switch name {
+	case "", PlacementLowestLatency:
+		return lowestLatencyStrategy{}, nil
+	case PlacementLeastKeys:
+		return leastKeysStrategy{}, nil
+	case PlacementLeastBytes:
+		return leastBytesStrategy{}, nil
+	case PlacementLatencyRandom:
+		return newLatencyRandomStrategy(rand.New(rand.NewSource(time.Now().UnixNano()))), nil
+	case PlacementConsistentHash:
+		return &consistentHashStrategy{ring: newHashRing(brokers, weights, ringVnodes())}, nil
+	}
+	return nil, fmt.Errorf("unknown placement strategy %q", name)
+}
+
+// placementStrategyFromConfig returns the configured strategy, falling back to the
+// lowest latency one
+func placementStrategyFromConfig(brokers []string, weights map[string]float64) PlacementStrategy {
+	strategy, err := newPlacementStrategy(viper.GetString("placement_strategy"), brokers, weights)
+	if err != nil {
+		log.Warnf("%s, using %s", err.Error(), PlacementLowestLatency)
+		return lowestLatencyStrategy{}
+	}
+	return strategy
+}
+
+// brokerWeight returns the capacity weight of a broker, 1 unless configured
+func brokerWeight(b *broker.Client) float64 {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+// ringVnodes returns the number of points of a broker of weight 1 on the hash ring
+func ringVnodes() int {
+	if n := viper.GetInt("ring_vnodes"); n > 0 {
+		return n
+	}
+	return 64
+}
+
+// sortCandidates sorts the candidates by less, then by broker name so the ranking
+// doesn't depend on the order of the brokers map
+func sortCandidates(candidates []PlacementCandidate, less func(a, b PlacementCandidate) bool) []PlacementCandidate {
+	res := append([]PlacementCandidate(nil), candidates...)
+	sort.SliceStable(res, func(i, j int) bool {
+		if less(res[i], res[j]) {
+			return true
+		}
+		if less(res[j], res[i]) {
+			return false
+		}
+		return res[i].Broker.Name < res[j].Broker.Name
+	})
+	return res
+}
+
+// lowestLatencyStrategy prefers the brokers with the lowest health check latency
+type lowestLatencyStrategy struct{}
+
+func (lowestLatencyStrategy) Name() string {
+	return PlacementLowestLatency
+}
+
+func (lowestLatencyStrategy) Rank(key string, candidates []PlacementCandidate) []PlacementCandidate {
+	return sortCandidates(candidates, func(a, b PlacementCandidate) bool {
+		return a.Broker.Latency < b.Broker.Latency
+	})
+}
+
+// leastKeysStrategy prefers the brokers holding the fewest keys for their weight, and
+// the heaviest brokers among equally loaded ones
+type leastKeysStrategy struct{}
+
+func (leastKeysStrategy) Name() string {
+	return PlacementLeastKeys
+}
+
+func (leastKeysStrategy) Rank(key string, candidates []PlacementCandidate) []PlacementCandidate {
+	return sortCandidates(candidates, func(a, b PlacementCandidate) bool {
+		if a.keyLoad() != b.keyLoad() {
+			return a.keyLoad() < b.keyLoad()
+		}
+		return brokerWeight(a.Broker) > brokerWeight(b.Broker)
+	})
+}
+
+// leastBytesStrategy prefers the brokers with the fewest queued bytes for their weight,
+// then the ones holding the fewest keys for their weight
+type leastBytesStrategy struct{}
+
+func (leastBytesStrategy) Name() string {
+	return PlacementLeastBytes
+}
+
+func (leastBytesStrategy) Rank(key string, candidates []PlacementCandidate) []PlacementCandidate {
+	return sortCandidates(candidates, func(a, b PlacementCandidate) bool {
+		if a.byteLoad() != b.byteLoad() {
+			return a.byteLoad() < b.byteLoad()
+		}
+		if a.keyLoad() != b.keyLoad() {
+			return a.keyLoad() < b.keyLoad()
+		}
+		return brokerWeight(a.Broker) > brokerWeight(b.Broker)
+	})
+}
+
+// latencyRandomStrategy draws the brokers at random without replacement, with a
+// probability proportional to their weight and inversely proportional to their
+// latency. Fast brokers get most keys without the fastest one getting all of them.
+type latencyRandomStrategy struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newLatencyRandomStrategy(r *rand.Rand) *latencyRandomStrategy {
+	return &latencyRandomStrategy{rand: r}
+}
+
+func (p *latencyRandomStrategy) Name() string {
+	return PlacementLatencyRandom
+}
+
+func (p *latencyRandomStrategy) Rank(key string, candidates []PlacementCandidate) []PlacementCandidate {
+	left := sortCandidates(candidates, func(a, b PlacementCandidate) bool { return false })
+	weights := make([]float64, len(left))
+	for i, c := range left {
+		latency := c.Broker.Latency
+		if latency < time.Millisecond {
+			latency = time.Millisecond
+		}
+		weights[i] = brokerWeight(c.Broker) / latency.Seconds()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]PlacementCandidate, 0, len(left))
+	for len(left) > 0 {
+		total := 0.0
+		for _, w := range weights {
+			total += w
+		}
+		pick := len(left) - 1
+		x := p.rand.Float64() * total
+		for i, w := range weights {
+			if x < w {
+				pick = i
+				break
+			}
+			x -= w
+		}
+		res = append(res, left[pick])
+		left = append(left[:pick], left[pick+1:]...)
+		weights = append(weights[:pick], weights[pick+1:]...)
+	}
+	return res
+}
+
+// consistentHashStrategy ranks the brokers in the order they follow the key on a hash
+// ring, so a key lands on the same brokers as long as they are candidates and adding
+// a broker only takes keys from its neighbours
+type consistentHashStrategy struct {
+	ring *hashRing
+}
+
+func (p *consistentHashStrategy) Name() string {
+	return PlacementConsistentHash
+}
+
+func (p *consistentHashStrategy) Rank(key string, candidates []PlacementCandidate) []PlacementCandidate {
+	byName := make(map[string]PlacementCandidate, len(candidates))
+	for _, c := range candidates {
+		byName[c.Broker.Name] = c
+	}
+	res := make([]PlacementCandidate, 0, len(candidates))
+	for _, name := range p.ring.walk(key) {
+		if c, ok := byName[name]; ok {
+			res = append(res, c)
+			delete(byName, name)
+		}
+	}
+	// Brokers missing from the ring go last
+	rest := make([]PlacementCandidate, 0, len(byName))
+	for _, c := range byName {
+		rest = append(rest, c)
+	}
+	return append(res, sortCandidates(rest, func(a, b PlacementCandidate) bool { return false })...)
+}
+
+// hashRing places every broker at several points of a 32-bit hash ring, in proportion
+// to its weight
+type hashRing struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash   uint32
+	broker string
+}
+
+func newHashRing(brokers []string, weights map[string]float64, vnodes int) *hashRing {
+	r := &hashRing{}
+	for _, name := range brokers {
+		points := vnodes
+		if w, ok := weights[name]; ok && w > 0 {
+			points = int(math.Max(1, math.Round(w*float64(vnodes))))
+		}
+		for i := 0; i < points; i++ {
+			r.points = append(r.points, ringPoint{hash: ringHash(name + "#" + strconv.Itoa(i)), broker: name})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].broker < r.points[j].broker
+	})
+	return r
+}
+
+// walk returns the distinct brokers met going clockwise from the point of the key
+func (r *hashRing) walk(key string) []string {
+	if len(r.points) == 0 {
+		return nil
+	}
+	h := ringHash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	seen := map[string]bool{}
+	var res []string
+	for i := 0; i < len(r.points); i++ {
+		p := r.points[(start+i)%len(r.points)]
+		if !seen[p.broker] {
+			seen[p.broker] = true
+			res = append(res, p.broker)
+		}
+	}
+	return res
+}
+
+func ringHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// placementCandidates returns the healthy brokers that aren't draining with the number
+// of keys they hold and their queued bytes. A broker whose stats can't be read counts
+// as empty of bytes.
+func (s *Zookeeper) placementCandidates() ([]PlacementCandidate, error) {
+	keys, err := s.brokerKeyCounts()
+	if err != nil {
+		return nil, err
+	}
+	var res []PlacementCandidate
+	for _, b := range s.brokers {
+		if !b.Health || b.Draining() {
+			continue
+		}
+		c := PlacementCandidate{Broker: b, Keys: keys[b.Name]}
+		if stats, err := b.Stats(); err != nil {
+			log.WithFields(log.Fields{
+				"broker": b.Name,
+			}).Warnf("Couldn't get broker stats: %s", err.Error())
+		} else {
+			c.Bytes = stats.Bytes
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// brokerKeyCounts returns the number of keys held by every broker
+func (s *Zookeeper) brokerKeyCounts() (map[string]int, error) {
+	rows, err := s.db.Query("SELECT broker, count(*) FROM queues GROUP BY broker")
+	if err != nil {
+		log.Warnf("Couldn't count keys of brokers: %s", err.Error())
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Warnf("Couldn't close rows: %s", err.Error())
+		}
+	}(rows)
+
+	res := map[string]int{}
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		res[name] = count
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,229 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeCandidates returns candidates named broker1, broker2, ... with the given
+// latencies, key counts and queued bytes
+func fakeCandidates(latencies []time.Duration, keys []int, bytes []int64) []PlacementCandidate {
+	var res []PlacementCandidate
+	for i := range latencies {
+		b := broker.NewBroker(fmt.Sprintf("broker%d", i+1), "")
+		b.Health = true
+		b.Latency = latencies[i]
+		res = append(res, PlacementCandidate{Broker: b, Keys: keys[i], Bytes: bytes[i]})
+	}
+	return res
+}
+
+func rankedNames(ranked []PlacementCandidate) []string {
+	var res []string
+	for _, c := range ranked {
+		res = append(res, c.Broker.Name)
+	}
+	return res
+}
+
+func TestNewPlacementStrategy(t *testing.T) {
+	for _, name := range []string{"", PlacementLowestLatency, PlacementLeastKeys, PlacementLeastBytes, PlacementLatencyRandom, PlacementConsistentHash} {
+		strategy, err := newPlacementStrategy(name, []string{"broker1"}, nil)
+		if err != nil {
+			t.Fatalf("newPlacementStrategy(%q): %s", name, err)
+		}
+		if name != "" && strategy.Name() != name {
+			t.Errorf("newPlacementStrategy(%q).Name() = %q", name, strategy.Name())
+		}
+	}
+	if _, err := newPlacementStrategy("busiest", nil, nil); err == nil {
+		t.Error("newPlacementStrategy accepted an unknown strategy")
+	}
+}
+
+func TestLowestLatencyStrategy(t *testing.T) {
+	candidates := fakeCandidates(
+		[]time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond},
+		[]int{0, 0, 0, 0}, []int64{0, 0, 0, 0})
+
+	got := rankedNames(lowestLatencyStrategy{}.Rank("orders", candidates))
+	want := []string{"broker2", "broker4", "broker3", "broker1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rank() = %v, want %v", got, want)
+	}
+}
+
+func TestLeastKeysStrategy(t *testing.T) {
+	candidates := fakeCandidates(
+		[]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond},
+		[]int{5, 1, 3}, []int64{0, 1000, 0})
+
+	got := rankedNames(leastKeysStrategy{}.Rank("orders", candidates))
+	want := []string{"broker2", "broker3", "broker1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rank() = %v, want %v", got, want)
+	}
+}
+
+func TestLeastBytesStrategy(t *testing.T) {
+	candidates := fakeCandidates(
+		[]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond},
+		[]int{1, 4, 2}, []int64{500, 100, 100})
+
+	got := rankedNames(leastBytesStrategy{}.Rank("orders", candidates))
+	want := []string{"broker3", "broker2", "broker1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rank() = %v, want %v", got, want)
+	}
+}
+
+func TestLatencyRandomStrategy(t *testing.T) {
+	candidates := fakeCandidates(
+		[]time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond},
+		[]int{0, 0, 0}, []int64{0, 0, 0})
+	strategy := newLatencyRandomStrategy(rand.New(rand.NewSource(1)))
+
+	first := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		ranked := strategy.Rank("orders", candidates)
+		if len(ranked) != len(candidates) {
+			t.Fatalf("Rank() returned %d brokers, want %d", len(ranked), len(candidates))
+		}
+		seen := map[string]bool{}
+		for _, c := range ranked {
+			if seen[c.Broker.Name] {
+				t.Fatalf("Rank() returned %s twice", c.Broker.Name)
+			}
+			seen[c.Broker.Name] = true
+		}
+		first[ranked[0].Broker.Name]++
+	}
+	if !(first["broker1"] > first["broker2"] && first["broker2"] > first["broker3"]) {
+		t.Errorf("faster brokers should be ranked first more often, got %v", first)
+	}
+	if first["broker3"] == 0 {
+		t.Errorf("the slowest broker should still be ranked first sometimes, got %v", first)
+	}
+}
+
+func TestConsistentHashStrategy(t *testing.T) {
+	names := []string{"broker1", "broker2", "broker3", "broker4"}
+	candidates := fakeCandidates(
+		[]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond},
+		[]int{0, 0, 0, 0}, []int64{0, 0, 0, 0})
+	strategy := &consistentHashStrategy{ring: newHashRing(names, nil, 64)}
+
+	masters := map[string]string{}
+	count := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		ranked := strategy.Rank(key, candidates)
+		if len(ranked) != len(candidates) {
+			t.Fatalf("Rank(%q) returned %d brokers, want %d", key, len(ranked), len(candidates))
+		}
+		again := strategy.Rank(key, candidates)
+		if !reflect.DeepEqual(rankedNames(ranked), rankedNames(again)) {
+			t.Fatalf("Rank(%q) isn't stable: %v then %v", key, rankedNames(ranked), rankedNames(again))
+		}
+		masters[key] = ranked[0].Broker.Name
+		count[ranked[0].Broker.Name]++
+	}
+	for _, name := range names {
+		if count[name] < 100 {
+			t.Errorf("%s is the master of %d of 1000 keys, want a fairer share: %v", name, count[name], count)
+		}
+	}
+
+	// Without broker4, only the keys it was the master of move
+	for key, master := range masters {
+		ranked := strategy.Rank(key, candidates[:3])
+		if master != "broker4" && ranked[0].Broker.Name != master {
+			t.Errorf("key %s moved from %s to %s when broker4 left", key, master, ranked[0].Broker.Name)
+		}
+	}
+}
+
+func TestConsistentHashStrategyUnknownBroker(t *testing.T) {
+	candidates := fakeCandidates(
+		[]time.Duration{time.Millisecond, time.Millisecond},
+		[]int{0, 0}, []int64{0, 0})
+	strategy := &consistentHashStrategy{ring: newHashRing([]string{"broker1"}, nil, 16)}
+
+	got := rankedNames(strategy.Rank("orders", candidates))
+	want := []string{"broker1", "broker2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rank() = %v, want %v", got, want)
+	}
+}
+
+// labelCandidates returns candidates named broker1, broker2, ... in the given zones
+// and racks, ranked in that order
+func labelCandidates(zones, racks []string) []PlacementCandidate {
+	var res []PlacementCandidate
+	for i := range zones {
+		b := broker.NewBroker(fmt.Sprintf("broker%d", i+1), "")
+		b.Labels = map[string]string{"zone": zones[i], "rack": racks[i]}
+		res = append(res, PlacementCandidate{Broker: b})
+	}
+	return res
+}
+
+func TestSpreadBrokers(t *testing.T) {
+	domains := []string{"zone", "rack"}
+	candidates := labelCandidates(
+		[]string{"a", "a", "a", "b", "b"},
+		[]string{"r1", "r1", "r2", "r1", "r3"})
+
+	got := rankedNames(spreadBrokers(candidates, 3, nil, domains))
+	want := []string{"broker1", "broker4", "broker3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spreadBrokers() = %v, want %v", got, want)
+	}
+
+	// Zone b is taken, so the best pick is in zone a on another rack than broker1
+	taken := []*broker.Client{candidates[0].Broker, candidates[3].Broker}
+	got = rankedNames(spreadBrokers(candidates[1:3], 1, taken, domains))
+	want = []string{"broker3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spreadBrokers() with taken brokers = %v, want %v", got, want)
+	}
+
+	// Without failure domains the ranking is kept
+	got = rankedNames(spreadBrokers(candidates, 3, nil, nil))
+	want = []string{"broker1", "broker2", "broker3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spreadBrokers() without domains = %v, want %v", got, want)
+	}
+}
+
+func TestWeightedPlacement(t *testing.T) {
+	candidates := fakeCandidates(
+		[]time.Duration{time.Millisecond, time.Millisecond},
+		[]int{4, 3}, []int64{400, 300})
+	candidates[0].Broker.Weight = 2
+
+	// broker1 holds 2 keys per unit of weight and broker2 3
+	got := rankedNames(leastKeysStrategy{}.Rank("orders", candidates))
+	want := []string{"broker1", "broker2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("leastKeysStrategy.Rank() = %v, want %v", got, want)
+	}
+	got = rankedNames(leastBytesStrategy{}.Rank("orders", candidates))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("leastBytesStrategy.Rank() = %v, want %v", got, want)
+	}
+
+	names := []string{"broker1", "broker2"}
+	strategy := &consistentHashStrategy{ring: newHashRing(names, map[string]float64{"broker1": 3, "broker2": 1}, 64)}
+	count := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		count[strategy.Rank(fmt.Sprintf("key-%d", i), candidates)[0].Broker.Name]++
+	}
+	if count["broker1"] < 2*count["broker2"] {
+		t.Errorf("broker1 has 3 times the weight of broker2 but is the master of %v keys", count)
+	}
+}
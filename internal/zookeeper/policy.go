@@ -0,0 +1,262 @@
+package zookeeper
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Pop scheduling policies of pops from any key
+const (
+	PolicyRoundRobin   = "round_robin"
+	PolicyWeightedFair = "weighted_fair"
+	PolicyOldestFirst  = "oldest_first"
+)
+
+var (
+	popsServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zookeeper_pops_served_total",
+		Help: "Messages served to pops from any key, by key.",
+	}, []string{"key", "policy"})
+	popMessageAge = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zookeeper_pop_message_age_seconds",
+		Help:    "Time between the push and the pop of messages served to pops from any key, by key.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"key"})
+)
+
+// popCandidate is a key with a master broker that a pop from any key may serve
+type popCandidate struct {
+	Key    string
+	Weight int
+}
+
+// popPolicy decides the order in which a pop from any key tries the keys. It is told
+// which key was served so it can give the other keys their turn.
+type popPolicy interface {
+	Name() string
+	Order(s *Zookeeper, candidates []popCandidate) []string
+	Served(key string, weight int)
+}
+
+// newPopPolicy returns the policy named by pop_policy, round-robin by default
+func newPopPolicy(name string) (popPolicy, error) {
+	switch name {
+	case "", PolicyRoundRobin:
+		return &roundRobinPolicy{}, nil
+	case PolicyWeightedFair:
+		return &weightedFairPolicy{finish: map[string]float64{}}, nil
+	case PolicyOldestFirst:
+		return &oldestFirstPolicy{}, nil
+	}
+	return nil, fmt.Errorf("unknown pop policy %q", name)
+}
+
+// popPolicyFromConfig returns the configured policy, falling back to round-robin
+func popPolicyFromConfig() popPolicy {
+	policy, err := newPopPolicy(viper.GetString("pop_policy"))
+	if err != nil {
+		log.Warnf("%s, using %s", err.Error(), PolicyRoundRobin)
+		return &roundRobinPolicy{}
+	}
+	return policy
+}
+
+// roundRobinPolicy serves the keys in turn, in lexical order, starting after the last
+// served key
+type roundRobinPolicy struct {
+	mu   sync.Mutex
+	last string
+}
+
+func (p *roundRobinPolicy) Name() string {
+	return PolicyRoundRobin
+}
+
+func (p *roundRobinPolicy) Order(s *Zookeeper, candidates []popCandidate) []string {
+	keys := candidateKeys(candidates)
+	sort.Strings(keys)
+
+	p.mu.Lock()
+	last := p.last
+	p.mu.Unlock()
+	start := sort.Search(len(keys), func(i int) bool { return keys[i] > last })
+	return append(keys[start:len(keys):len(keys)], keys[:start]...)
+}
+
+func (p *roundRobinPolicy) Served(key string, weight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = key
+}
+
+// weightedFairPolicy is start-time fair queuing over the keys: every served message
+// advances the virtual finish time of its key by 1/weight, and the key that would
+// start earliest is tried first. A key with weight 2 gets twice the pops of a key with
+// weight 1 while both have messages, and idle keys don't build up credit.
+type weightedFairPolicy struct {
+	mu     sync.Mutex
+	clock  float64
+	finish map[string]float64
+}
+
+func (p *weightedFairPolicy) Name() string {
+	return PolicyWeightedFair
+}
+
+func (p *weightedFairPolicy) Order(s *Zookeeper, candidates []popCandidate) []string {
+	p.mu.Lock()
+	start := make(map[string]float64, len(candidates))
+	for _, c := range candidates {
+		start[c.Key] = p.start(c.Key)
+	}
+	p.mu.Unlock()
+
+	keys := candidateKeys(candidates)
+	sort.Slice(keys, func(i, j int) bool {
+		if start[keys[i]] != start[keys[j]] {
+			return start[keys[i]] < start[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func (p *weightedFairPolicy) Served(key string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	start := p.start(key)
+	p.finish[key] = start + 1/float64(weight)
+	p.clock = start
+}
+
+func (p *weightedFairPolicy) start(key string) float64 {
+	if finish := p.finish[key]; finish > p.clock {
+		return finish
+	}
+	return p.clock
+}
+
+// oldestFirstPolicy serves the key whose front message was pushed first. It peeks at
+// the front of every key, so it costs a broker call per key on every pop.
+type oldestFirstPolicy struct{}
+
+func (p *oldestFirstPolicy) Name() string {
+	return PolicyOldestFirst
+}
+
+func (p *oldestFirstPolicy) Order(s *Zookeeper, candidates []popCandidate) []string {
+	fronts := map[string]time.Time{}
+	var keys []string
+	for _, c := range candidates {
+		master := s.GetMasterBroker(c.Key)
+		if master == nil || !master.Health {
+			continue
+		}
+		elem, err := master.Peek(c.Key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key":    c.Key,
+				"broker": master.Name,
+			}).Warnf("Couldn't peek message: %s", err.Error())
+			continue
+		}
+		if elem.Key == "" {
+			continue
+		}
+		fronts[c.Key] = elem.Timestamp
+		keys = append(keys, c.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !fronts[keys[i]].Equal(fronts[keys[j]]) {
+			return fronts[keys[i]].Before(fronts[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func (p *oldestFirstPolicy) Served(key string, weight int) {}
+
+func candidateKeys(candidates []popCandidate) []string {
+	keys := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+// popCandidates returns the keys matching the pattern whose master broker is healthy,
+// with their weights. Dead-letter keys are left out unless the pattern names them.
+func (s *Zookeeper) popCandidates(pattern string) ([]popCandidate, error) {
+	rows, err := s.db.Query(`SELECT q.queue, q.broker, COALESCE(k.weight, 1) FROM queues q
+		LEFT JOIN key_settings k ON k.queue = q.queue WHERE q.is_master AND q.queue LIKE $1 ESCAPE '\'`, likePattern(pattern))
+	if err != nil {
+		log.Warnf("Couldn't get keys to pop from: %s", err.Error())
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Warnf("Couldn't close rows: %s", err.Error())
+		}
+	}(rows)
+
+	var res []popCandidate
+	for rows.Next() {
+		var c popCandidate
+		var brokerName string
+		if err := rows.Scan(&c.Key, &brokerName, &c.Weight); err != nil {
+			return nil, err
+		}
+		if b := s.brokers[brokerName]; b == nil || !b.Health {
+			continue
+		}
+		if isDeadLetterKey(c.Key) && !servesDeadLetters(pattern) {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// popScheduled leases a message of the first non-empty key matching the pattern in the
+// order of the pop policy and tells the policy which key was served
+func (s *Zookeeper) popScheduled(pattern string) (*delivery, error) {
+	candidates, err := s.popCandidates(pattern)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]int, len(candidates))
+	for _, c := range candidates {
+		weights[c.Key] = c.Weight
+	}
+
+	for _, key := range s.popPolicy.Order(s, candidates) {
+		d, err := s.popKey(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key": key,
+			}).Warnf("Couldn't pop from key: %s", err.Error())
+			continue
+		}
+		if d == nil {
+			continue
+		}
+		s.popPolicy.Served(key, weights[key])
+		popsServed.WithLabelValues(key, s.popPolicy.Name()).Inc()
+		popMessageAge.WithLabelValues(key).Observe(time.Since(d.Element.Timestamp).Seconds())
+		return d, nil
+	}
+	return nil, nil
+}
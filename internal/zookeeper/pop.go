@@ -0,0 +1,170 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"Zookeeper/internal/types"
+	"Zookeeper/pkg/glob"
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// errKeyNotFound is also returned by brokers asked for a key they don't hold
+var errKeyNotFound = broker.ErrKeyNotFound
+
+// popAny leases the front message of a key chosen by the pop policy among the keys
+// that have a healthy master, skipping expired messages. Messages waiting for
+// redelivery are served first. It returns nil if every queue is empty.
+func (s *Zookeeper) popAny() (*delivery, error) {
+	return s.popMatching(anyKey)
+}
+
+// popMatching is popAny restricted to the keys matching a glob pattern
+func (s *Zookeeper) popMatching(pattern string) (*delivery, error) {
+	d, err := s.claimExpiredLease(pattern, 0)
+	if d != nil || err != nil {
+		return d, err
+	}
+	return s.popScheduled(pattern)
+}
+
+// popper returns the pop of a key argument: a pop from any key if it is empty, from
+// the keys matching it if it is a pattern, or from the key itself
+func (s *Zookeeper) popper(key string) func() (*delivery, error) {
+	switch {
+	case key == anyKey:
+		return s.popAny
+	case glob.IsPattern(key):
+		return func() (*delivery, error) {
+			return s.popMatching(key)
+		}
+	}
+	return func() (*delivery, error) {
+		return s.popKey(key)
+	}
+}
+
+// popKey leases the front message of the key from its master broker. Expired messages
+// are skipped. The message is leased before it is removed from the master, and erased
+// from the replicas once the lease is acknowledged. A message waiting for redelivery
+// is served instead if its priority is at least the one of the front message. It
+// returns nil if the queue is empty.
+func (s *Zookeeper) popKey(key string) (*delivery, error) {
+	master := s.GetMasterBroker(key)
+	if master == nil {
+		d, err := s.claimExpiredLease(key, 0)
+		if d != nil || err != nil {
+			return d, err
+		}
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Info("No master broker found for key")
+		return nil, errKeyNotFound
+	}
+
+	for {
+		log.WithFields(log.Fields{
+			"broker": master.Name,
+			"key":    key,
+		}).Info("Popping message from master broker")
+		res, err := master.Peek(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"broker": master.Name,
+				"key":    key,
+			}).Warnf("Couldn't peek message: %s", err.Error())
+			d, claimErr := s.claimExpiredLease(key, 0)
+			if d != nil || claimErr != nil {
+				return d, claimErr
+			}
+			return nil, err
+		}
+		if res.Key == "" {
+			return s.claimExpiredLease(key, 0)
+		}
+		if expired(res) {
+			if err := master.RemoveMessage(key, res.ID); err != nil {
+				return nil, err
+			}
+			s.discardExpired(res)
+			continue
+		}
+		d, err := s.claimExpiredLease(key, res.Priority)
+		if d != nil || err != nil {
+			return d, err
+		}
+
+		d, err = s.lease(res)
+		if err != nil {
+			return nil, err
+		}
+		err = master.RemoveMessage(key, res.ID)
+		if d == nil {
+			// Leased already, so it only has to leave the master
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			// The next pop finds the message leased and removes it again
+			log.WithFields(log.Fields{
+				"broker": master.Name,
+				"key":    key,
+				"id":     res.ID,
+			}).Warnf("Couldn't remove leased message from master: %s", err.Error())
+		}
+		return d, nil
+	}
+}
+
+// discardExpired erases a message whose TTL passed from the brokers of its key
+// after it was removed from the master
+func (s *Zookeeper) discardExpired(elem *types.Element) {
+	log.WithFields(log.Fields{
+		"key": elem.Key,
+		"id":  elem.ID,
+	}).Info("Discarding expired message")
+	s.EraseMessage(elem.Key, elem.ID)
+	removeBlob(elem)
+}
+
+// popWait calls pop until it returns a message, the wait duration passes or the
+// context is done. Waiters are woken up by pushes to the key instead of polling
+// the brokers. An empty key waits for a message on any key.
+func (s *Zookeeper) popWait(ctx context.Context, key string, wait time.Duration, pop func() (*delivery, error)) (*delivery, error) {
+	if maxWait := viper.GetDuration("max_pop_wait"); maxWait > 0 && wait > maxWait {
+		wait = maxWait
+	}
+	if wait <= 0 {
+		return pop()
+	}
+
+	ch := s.notifier.Subscribe(key)
+	defer s.notifier.Unsubscribe(ch, key)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		res, err := pop()
+		if res != nil || (err != nil && !errors.Is(err, errKeyNotFound)) {
+			return res, err
+		}
+
+		log.WithFields(log.Fields{
+			"key":  key,
+			"wait": wait,
+		}).Debug("Waiting for a message to be pushed")
+		select {
+		case <-ch:
+		case <-timer.C:
+			return res, err
+		case <-ctx.Done():
+			return res, err
+		}
+	}
+}
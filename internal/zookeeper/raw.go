@@ -0,0 +1,147 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// rawContentType is the content type of raw message values
+const rawContentType = "application/octet-stream"
+
+// headerPrefix prefixes the message headers sent as HTTP headers by the raw routes
+const headerPrefix = "X-Message-Header-"
+
+// PushRaw pushes the request body as the value of a message of the key. The message
+// options are read from the X-Message-TTL, X-Message-Delay, X-Message-Deliver-At and
+// X-Message-Priority headers and its headers from the X-Message-Header-* headers.
+// Bodies larger than the blob threshold are streamed to a blob.
+func (s *Zookeeper) PushRaw(c *gin.Context) {
+	req, err := rawPushRequest(c)
+	if err != nil {
+		fail(c, invalid(err))
+		return
+	}
+
+	body := c.Request.Body
+	if max := maxMessageSize(); max > 0 {
+		if c.Request.ContentLength > max {
+			fail(c, checkMessageSize(c.Request.ContentLength))
+			return
+		}
+		body = http.MaxBytesReader(c.Writer, body, max)
+	}
+	value, blob, err := readPayload(body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		err = checkMessageSize(maxBytesErr.Limit + 1)
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	req.Value = value
+	req.Blob = blob
+
+	result, err := s.push(req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.PushResponse{ID: result.ID, Key: result.Key, DeliverAt: result.DeliverAt})
+}
+
+// rawPushRequest builds a push request from the headers of a raw push
+func rawPushRequest(c *gin.Context) (*types.PushRequest, error) {
+	req := &types.PushRequest{
+		Key:            c.Param("key"),
+		TTL:            c.GetHeader("X-Message-TTL"),
+		Delay:          c.GetHeader("X-Message-Delay"),
+		IdempotencyKey: c.GetHeader("Idempotency-Key"),
+	}
+	if deliverAt := c.GetHeader("X-Message-Deliver-At"); deliverAt != "" {
+		t, err := time.Parse(time.RFC3339, deliverAt)
+		if err != nil {
+			return nil, err
+		}
+		req.DeliverAt = &t
+	}
+	if priority := c.GetHeader("X-Message-Priority"); priority != "" {
+		p, err := strconv.Atoi(priority)
+		if err != nil {
+			return nil, err
+		}
+		req.Priority = p
+	}
+	for name, values := range c.Request.Header {
+		if !strings.HasPrefix(name, headerPrefix) || len(values) == 0 {
+			continue
+		}
+		if req.Headers == nil {
+			req.Headers = make(map[string]string)
+		}
+		req.Headers[strings.ToLower(strings.TrimPrefix(name, headerPrefix))] = values[0]
+	}
+	return req, nil
+}
+
+// wantsRaw reports whether the client asked for the raw value of popped messages
+func wantsRaw(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), rawContentType)
+}
+
+// respondDelivery writes a leased message. Clients accepting application/octet-stream
+// get the value as the body and the message fields as headers, or 204 if no message
+// was available; others get a PopResponse.
+func respondDelivery(c *gin.Context, d *delivery) {
+	if !wantsRaw(c) {
+		if d == nil {
+			respond(c, http.StatusOK, &types.PopResponse{})
+			return
+		}
+		if err := loadValue(d.Element); err != nil {
+			fail(c, err)
+			return
+		}
+		respond(c, http.StatusOK, &types.PopResponse{Delivery: newDelivery(d)})
+		return
+	}
+
+	if d == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	value, size, err := openValue(d.Element)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key":  d.Element.Key,
+			"id":   d.ID,
+			"blob": d.Element.Blob,
+		}).Warnf("Couldn't open message value: %s", err.Error())
+		fail(c, err)
+		return
+	}
+	defer value.Close()
+
+	headers := map[string]string{
+		"X-Message-ID":        d.ID,
+		"X-Message-Key":       d.Element.Key,
+		"X-Message-Priority":  strconv.Itoa(d.Element.Priority),
+		"X-Message-Attempts":  strconv.Itoa(d.Attempts),
+		"X-Message-Timestamp": d.Element.Timestamp.Format(time.RFC3339Nano),
+		"X-Lease-Deadline":    d.Deadline.Format(time.RFC3339Nano),
+	}
+	if d.Element.Seq > 0 {
+		headers["X-Message-Seq"] = strconv.FormatInt(d.Element.Seq, 10)
+	}
+	for name, v := range d.Element.Headers {
+		headers[headerPrefix+name] = v
+	}
+	c.DataFromReader(http.StatusOK, size, rawContentType, value, headers)
+}
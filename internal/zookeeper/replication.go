@@ -0,0 +1,328 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"Zookeeper/internal/types"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// replicationFactor returns the number of brokers holding a copy of the key, the
+// master included. Keys without their own factor use the replica setting.
+func (s *Zookeeper) replicationFactor(key string) int {
+	var factor int
+	err := s.db.QueryRow("SELECT replication_factor FROM key_settings WHERE queue = $1", key).Scan(&factor)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't get replication factor: %s", err.Error())
+	}
+	if factor <= 0 {
+		return s.replica
+	}
+	return factor
+}
+
+func (s *Zookeeper) validateReplicationFactor(factor int) error {
+	if factor < 1 {
+		return invalid(errors.New("replication_factor must be at least 1"))
+	}
+	if factor > len(s.brokers) {
+		return invalid(fmt.Errorf("replication_factor must not exceed the %d brokers", len(s.brokers)))
+	}
+	return nil
+}
+
+// initReplicationFactor stores the replication factor a push asks for its key. It is
+// ignored if the key is already assigned or has a factor; the admin API changes it.
+func (s *Zookeeper) initReplicationFactor(key string, factor int) error {
+	if err := s.validateReplicationFactor(factor); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO key_settings (queue, replication_factor)
+		SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM queues WHERE queue = $1)
+		ON CONFLICT (queue) DO UPDATE SET replication_factor = $2 WHERE key_settings.replication_factor = 0`, key, factor)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't store replication factor: %s", err.Error())
+	}
+	return err
+}
+
+// setReplicationFactor stores the replication factor of the key and, if the key is
+// assigned, adds copies to or removes replicas from brokers until it has as many
+// copies. New copies are assigned before their messages are copied, so pushes made
+// meanwhile reach them too; removed replicas are unassigned before they are deleted
+// from their brokers.
+func (s *Zookeeper) setReplicationFactor(key string, factor int) (*types.KeyAssignment, error) {
+	if err := s.validateReplicationFactor(factor); err != nil {
+		return nil, err
+	}
+	k, added, removed, err := s.storeReplicationFactor(key, factor)
+	if err != nil || k.Master == "" {
+		return k, err
+	}
+	if err := s.pinRoute(key); err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't update route of key: %s", err.Error())
+	}
+
+	master := s.brokers[k.Master]
+	for _, target := range added {
+		if err := s.syncCopy(key, master, target); err != nil {
+			s.dropCopy(key, target.Name)
+			return nil, err
+		}
+	}
+	for _, name := range removed {
+		b := s.brokers[name]
+		if b == nil {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"key":    key,
+			"broker": name,
+		}).Info("Removing copy of key")
+		if err := b.RemoveKey(key); err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": name,
+			}).Warnf("Couldn't remove key from broker: %s", err.Error())
+		}
+	}
+	log.WithFields(log.Fields{
+		"key":     key,
+		"factor":  factor,
+		"added":   len(added),
+		"removed": len(removed),
+	}).Info("Changed replication factor of key")
+	return k, nil
+}
+
+// storeReplicationFactor stores the replication factor of the key and, if the key is
+// assigned, assigns it to the brokers taking the new copies or unassigns the surplus
+// replicas. It returns the new assignment, an empty one if the key isn't assigned, the
+// added brokers and the removed replicas. The assignment lock of the key is held,
+// so the change doesn't race with the assignment of the key by another zookeeper.
+func (s *Zookeeper) storeReplicationFactor(key string, factor int) (*types.KeyAssignment, []*broker.Client, []string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", assignLockClass, key); err != nil {
+		return nil, nil, nil, err
+	}
+	_, err = tx.Exec(`INSERT INTO key_settings (queue, replication_factor) VALUES ($1, $2)
+		ON CONFLICT (queue) DO UPDATE SET replication_factor = $2`, key, factor)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't store replication factor: %s", err.Error())
+		return nil, nil, nil, err
+	}
+
+	assignments, err := s.keyAssignments(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var k *types.KeyAssignment
+	for i := range assignments {
+		if assignments[i].Key == key {
+			k = &assignments[i]
+		}
+	}
+	if k == nil || k.Master == "" {
+		// The factor applies once the key is assigned
+		return &types.KeyAssignment{Key: key, Replicas: []string{}}, nil, nil, tx.Commit()
+	}
+
+	var added []*broker.Client
+	var removed []string
+	holders := append([]string{k.Master}, k.Replicas...)
+	if factor > len(holders) {
+		if master := s.brokers[k.Master]; master == nil || !master.Health {
+			return nil, nil, nil, fmt.Errorf("master broker %s of key %s is not healthy", k.Master, key)
+		}
+	}
+	for len(holders) < factor {
+		target := s.replacementBroker(key, holders, holders)
+		if target == nil {
+			s.unassignKey(key, added)
+			return nil, nil, nil, fmt.Errorf("no broker can take another copy of key %s", key)
+		}
+		if err := target.AddKey(key, false); err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": target.Name,
+			}).Warnf("Couldn't add key to broker upstream: %s", err.Error())
+			s.unassignKey(key, added)
+			return nil, nil, nil, err
+		}
+		added = append(added, target)
+		if _, err := tx.Exec("INSERT INTO queues (queue, broker, is_master) VALUES ($1, $2, False)", key, target.Name); err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": target.Name,
+			}).Warnf("Couldn't add key to database: %s", err.Error())
+			s.unassignKey(key, added)
+			return nil, nil, nil, err
+		}
+		holders = append(holders, target.Name)
+		k.Replicas = append(k.Replicas, target.Name)
+	}
+	if factor < len(holders) {
+		for _, name := range surplusReplicas(s.brokers, k.Replicas, len(holders)-factor) {
+			if _, err := tx.Exec("DELETE FROM queues WHERE queue = $1 AND broker = $2", key, name); err != nil {
+				log.WithFields(log.Fields{
+					"key":    key,
+					"broker": name,
+				}).Warnf("Couldn't remove key from database: %s", err.Error())
+				return nil, nil, nil, err
+			}
+			removed = append(removed, name)
+		}
+		k.Replicas = remaining(k.Replicas, removed)
+	}
+	if err := tx.Commit(); err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't store key assignment: %s", err.Error())
+		s.unassignKey(key, added)
+		return nil, nil, nil, err
+	}
+	return k, added, removed, nil
+}
+
+// syncCopy imports into a new copy of the key the messages of the master it doesn't
+// hold. The first pass copies the messages pushed before the copy was assigned the
+// key; the second one backfills the messages of pushes that were already on their way
+// to the master only when the first pass exported it.
+func (s *Zookeeper) syncCopy(key string, master, target *broker.Client) error {
+	for pass := 0; pass < 2; pass++ {
+		source, err := master.Export(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"broker": master.Name,
+				"key":    key,
+			}).Errorf("Couldn't export key: %s", err.Error())
+			return err
+		}
+		held, err := target.Export(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"broker": target.Name,
+				"key":    key,
+			}).Errorf("Couldn't export key: %s", err.Error())
+			return err
+		}
+
+		var missing []types.Element
+		if pass == 0 {
+			// Messages pushed before sequence numbers were assigned can't be matched
+			// and only exist on the master
+			for _, m := range source.Messages {
+				if m.Seq == 0 {
+					missing = append(missing, m)
+				}
+			}
+		}
+		missing = append(missing, missingSeqs(held.Messages, source.Messages)...)
+		if len(missing) == 0 {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"key":    key,
+			"broker": target.Name,
+			"count":  len(missing),
+		}).Info("Copying messages to new copy of key")
+		if err := target.Import(key, false, missing); err != nil {
+			log.WithFields(log.Fields{
+				"broker": target.Name,
+				"key":    key,
+			}).Errorf("Couldn't import key: %s", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// dropCopy unassigns the key from a broker whose copy couldn't be filled and removes
+// the key from it
+func (s *Zookeeper) dropCopy(key, name string) {
+	if _, err := s.db.Exec("DELETE FROM queues WHERE queue = $1 AND broker = $2", key, name); err != nil {
+		log.WithFields(log.Fields{
+			"key":    key,
+			"broker": name,
+		}).Warnf("Couldn't remove key from database: %s", err.Error())
+		return
+	}
+	if err := s.pinRoute(key); err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't update route of key: %s", err.Error())
+	}
+	if b := s.brokers[name]; b != nil {
+		s.unassignKey(key, []*broker.Client{b})
+	}
+}
+
+// surplusReplicas returns count of the replicas to remove, unhealthy and unknown
+// brokers first and then the last ones by name
+func surplusReplicas(brokers map[string]*broker.Client, replicas []string, count int) []string {
+	healthy := func(name string) bool {
+		b := brokers[name]
+		return b != nil && b.Health
+	}
+	res := append([]string(nil), replicas...)
+	sort.SliceStable(res, func(i, j int) bool {
+		if healthy(res[i]) != healthy(res[j]) {
+			return !healthy(res[i])
+		}
+		return res[i] > res[j]
+	})
+	if count > len(res) {
+		count = len(res)
+	}
+	return res[:count]
+}
+
+// remaining returns the replicas without the removed ones
+func remaining(replicas []string, removed []string) []string {
+	gone := map[string]bool{}
+	for _, name := range removed {
+		gone[name] = true
+	}
+	res := []string{}
+	for _, name := range replicas {
+		if !gone[name] {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// SetReplicationFactor sets the number of brokers holding a copy of a key and adds or
+// removes copies to match it
+func (s *Zookeeper) SetReplicationFactor(c *gin.Context) {
+	req := &types.ReplicationRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	k, err := s.setReplicationFactor(c.Param("key"), req.Factor)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, k)
+}
@@ -0,0 +1,36 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"reflect"
+	"testing"
+)
+
+func TestSurplusReplicas(t *testing.T) {
+	brokers := map[string]*broker.Client{
+		"b1": {Name: "b1", Health: true},
+		"b2": {Name: "b2", Health: false},
+		"b3": {Name: "b3", Health: true},
+	}
+	tests := []struct {
+		replicas []string
+		count    int
+		want     []string
+	}{
+		{[]string{"b1", "b3"}, 1, []string{"b3"}},
+		{[]string{"b1", "b2", "b3"}, 1, []string{"b2"}},
+		{[]string{"b1", "b2", "b3"}, 2, []string{"b2", "b3"}},
+		{[]string{"b1", "gone", "b2"}, 2, []string{"gone", "b2"}},
+		{[]string{"b1"}, 3, []string{"b1"}},
+		{[]string{}, 1, nil},
+	}
+	for _, tt := range tests {
+		got := surplusReplicas(brokers, tt.replicas, tt.count)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("surplusReplicas(%v, %d) = %v, want %v", tt.replicas, tt.count, got, tt.want)
+		}
+		if left := remaining(tt.replicas, got); len(left)+len(got) != len(tt.replicas) {
+			t.Errorf("remaining(%v, %v) = %v", tt.replicas, got, left)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"Zookeeper/pkg/glob"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+var errQueueFull = errors.New("queue is full")
+
+// expired reports whether the TTL of a message has passed
+func expired(elem *types.Element) bool {
+	return elem.ExpiresAt != nil && time.Now().After(*elem.ExpiresAt)
+}
+
+// keySettings returns the retention settings of the key. A key without settings
+// has no default TTL, no maximum length and a pop weight of 1.
+func (s *Zookeeper) keySettings(key string) (*types.KeySettings, error) {
+	settings := &types.KeySettings{}
+	var overflowKey sql.NullString
+	err := s.db.QueryRow("SELECT default_ttl, max_length, overflow_policy, overflow_key, weight FROM key_settings WHERE queue = $1", key).
+		Scan(&settings.DefaultTTL, &settings.MaxLength, &settings.OverflowPolicy, &overflowKey, &settings.Weight)
+	if errors.Is(err, sql.ErrNoRows) {
+		settings.Weight = 1
+		return settings, nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't get key settings: %s", err.Error())
+		return nil, err
+	}
+	settings.OverflowKey = overflowKey.String
+	return settings, nil
+}
+
+func validateKeySettings(key string, settings *types.KeySettings) error {
+	if settings.DefaultTTL != "" {
+		d, err := time.ParseDuration(settings.DefaultTTL)
+		if err != nil {
+			return invalid(err)
+		}
+		if d <= 0 {
+			return invalid(errors.New("default_ttl must be positive"))
+		}
+	}
+	if settings.MaxLength < 0 {
+		return invalid(errors.New("max_length must not be negative"))
+	}
+	switch {
+	case settings.Weight < 0:
+		return invalid(errors.New("weight must not be negative"))
+	case settings.Weight == 0:
+		settings.Weight = 1
+	}
+	switch settings.OverflowPolicy {
+	case "":
+		settings.OverflowPolicy = types.OverflowReject
+	case types.OverflowReject, types.OverflowDropOldest:
+	case types.OverflowRoute:
+		if settings.OverflowKey == "" || settings.OverflowKey == key {
+			return invalid(errors.New("overflow_key must be set to another key"))
+		}
+	default:
+		return invalid(errors.New("unknown overflow_policy"))
+	}
+	return nil
+}
+
+// admission counts the messages a push request admits to each key, so the messages of
+// a batch are checked against the maximum length of their key together. It remembers
+// the messages that overflowed a key with the drop_oldest policy, which evict the
+// oldest message of their key once they are pushed.
+type admission struct {
+	queued map[string]int
+	evict  map[string]bool
+}
+
+func newAdmission() *admission {
+	return &admission{
+		queued: map[string]int{},
+		evict:  map[string]bool{},
+	}
+}
+
+// prepareElement builds the message of a push request like prepareMessage and stores
+// its value in a blob if it is larger than the blob threshold
+func (s *Zookeeper) prepareElement(req *types.PushRequest, adm *admission) (*types.Element, error) {
+	if req.Blob == "" {
+		if err := checkMessageSize(int64(len(req.Value))); err != nil {
+			return nil, err
+		}
+	}
+	elem, err := s.prepareMessage(req, adm)
+	if err != nil {
+		return nil, err
+	}
+
+	elem.Blob = req.Blob
+	if threshold := blobThreshold(); elem.Blob == "" && threshold > 0 && int64(len(elem.Value)) > threshold {
+		blob, err := storeBlob(bytes.NewReader(elem.Value))
+		if err != nil {
+			return nil, err
+		}
+		elem.Value = nil
+		elem.Blob = blob
+	}
+	return elem, nil
+}
+
+// prepareMessage builds the message of a push request. It applies the delivery time,
+// the default TTL and the overflow policy of the key, which may route the message to
+// another key. The TTL of a delayed message starts when it is delivered.
+func (s *Zookeeper) prepareMessage(req *types.PushRequest, adm *admission) (*types.Element, error) {
+	if glob.IsPattern(req.Key) {
+		return nil, invalid(errors.New("key must not contain * or ?"))
+	}
+	if req.Priority < 0 || req.Priority > types.MaxPriority {
+		return nil, invalid(fmt.Errorf("priority must be between 0 and %d", types.MaxPriority))
+	}
+	if req.ReplicationFactor != 0 {
+		if err := s.initReplicationFactor(req.Key, req.ReplicationFactor); err != nil {
+			return nil, err
+		}
+	}
+	elem := &types.Element{
+		Key:      req.Key,
+		Value:    req.Value,
+		Headers:  req.Headers,
+		Priority: req.Priority,
+	}
+	stampElement(elem)
+
+	settings, err := s.keySettings(req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := scheduleElement(elem, req); err != nil {
+		return nil, invalid(err)
+	}
+
+	ttl := req.TTL
+	if ttl == "" {
+		ttl = settings.DefaultTTL
+	}
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, invalid(err)
+		}
+		if d <= 0 {
+			return nil, invalid(errors.New("ttl must be positive"))
+		}
+		visibleAt := elem.Timestamp
+		if elem.DeliverAt != nil {
+			visibleAt = *elem.DeliverAt
+		}
+		expiresAt := visibleAt.Add(d)
+		elem.ExpiresAt = &expiresAt
+	}
+
+	if scheduled(elem) {
+		// The overflow policy applies once the message is delivered
+		return elem, nil
+	}
+	if err := s.overflow(elem, settings, adm); err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+// applyOverflow applies the overflow policy of the key of a message that is about to
+// be pushed
+func (s *Zookeeper) applyOverflow(elem *types.Element, adm *admission) error {
+	settings, err := s.keySettings(elem.Key)
+	if err != nil {
+		return err
+	}
+	return s.overflow(elem, settings, adm)
+}
+
+// overflow checks the message against the maximum length of its key. A message over
+// the limit is rejected, routed to the overflow key or marked to evict the oldest
+// message of the key, depending on the overflow policy.
+func (s *Zookeeper) overflow(elem *types.Element, settings *types.KeySettings, adm *admission) error {
+	if settings.MaxLength <= 0 {
+		return nil
+	}
+	key := elem.Key
+	queued, ok := adm.queued[key]
+	if !ok {
+		size, err := s.keySize(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key": key,
+			}).Warnf("Couldn't get key size: %s", err.Error())
+			return err
+		}
+		queued = size.Size
+	}
+	if queued < settings.MaxLength {
+		adm.queued[key] = queued + 1
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"key":        key,
+		"size":       queued,
+		"max_length": settings.MaxLength,
+		"policy":     settings.OverflowPolicy,
+	}).Info("Key reached its maximum length")
+	switch settings.OverflowPolicy {
+	case types.OverflowDropOldest:
+		adm.queued[key] = queued
+		adm.evict[elem.ID] = true
+	case types.OverflowRoute:
+		elem.Key = settings.OverflowKey
+	default:
+		return errQueueFull
+	}
+	return nil
+}
+
+// evictOldest drops the oldest message of the key of a pushed message if the message
+// overflowed its key with the drop_oldest policy
+func (s *Zookeeper) evictOldest(adm *admission, elem *types.Element) {
+	if !adm.evict[elem.ID] {
+		return
+	}
+	master := s.GetMasterBroker(elem.Key)
+	if master == nil {
+		return
+	}
+	res, err := master.Pop(elem.Key)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key":    elem.Key,
+			"broker": master.Name,
+		}).Warnf("Couldn't drop oldest message: %s", err.Error())
+		return
+	}
+	if res.Key != "" {
+		s.EraseMessage(elem.Key, res.ID)
+		removeBlob(res)
+	}
+}
+
+// keySize returns the number of messages and the queued bytes of the key
+func (s *Zookeeper) keySize(key string) (*types.KeySizeResponse, error) {
+	master := s.GetMasterBroker(key)
+	if master == nil {
+		return &types.KeySizeResponse{}, nil
+	}
+	size, err := master.Size(key)
+	if errors.Is(err, errKeyNotFound) {
+		return &types.KeySizeResponse{}, nil
+	}
+	return size, err
+}
+
+// setKeySettings validates and stores the retention settings of the key
+func (s *Zookeeper) setKeySettings(key string, settings *types.KeySettings) error {
+	if err := validateKeySettings(key, settings); err != nil {
+		return err
+	}
+
+	var overflowKey sql.NullString
+	if settings.OverflowPolicy == types.OverflowRoute {
+		overflowKey = sql.NullString{String: settings.OverflowKey, Valid: true}
+	}
+	_, err := s.db.Exec(`INSERT INTO key_settings (queue, default_ttl, max_length, overflow_policy, overflow_key, weight)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (queue) DO UPDATE SET default_ttl = $2, max_length = $3, overflow_policy = $4, overflow_key = $5, weight = $6`,
+		key, settings.DefaultTTL, settings.MaxLength, settings.OverflowPolicy, overflowKey, settings.Weight)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't store key settings: %s", err.Error())
+		return err
+	}
+	log.WithFields(log.Fields{
+		"key":      key,
+		"settings": settings,
+	}).Info("Updated key settings")
+	return nil
+}
+
+// GetKeySettings returns the retention settings and the current size of a key
+func (s *Zookeeper) GetKeySettings(c *gin.Context) {
+	key := c.Param("key")
+	settings, err := s.keySettings(key)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	size, err := s.keySize(key)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.KeySettingsResponse{
+		Key:               key,
+		Settings:          *settings,
+		ReplicationFactor: s.replicationFactor(key),
+		Size:              size.Size,
+		Bytes:             size.Bytes,
+	})
+}
+
+// SetKeySettings sets the default TTL, the maximum length, the overflow policy and the
+// pop weight of a key
+func (s *Zookeeper) SetKeySettings(c *gin.Context) {
+	key := c.Param("key")
+	settings := &types.KeySettings{}
+	if err := c.ShouldBindJSON(settings); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	if err := s.setKeySettings(key, settings); err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.OKResponse{Message: "ok"})
+}
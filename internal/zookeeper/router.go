@@ -0,0 +1,398 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"Zookeeper/internal/types"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Routing modes deciding how the brokers of a key are found
+const (
+	RoutingTable          = "table"
+	RoutingConsistentHash = "consistent_hash"
+)
+
+// ringLockID is the advisory lock serializing ring version changes between zookeepers
+const ringLockID = 7_283_041
+
+// route is the master and the replica brokers of a key
+type route struct {
+	Master   string
+	Replicas []string
+}
+
+// equal reports whether both routes have the same master and the same replicas
+func (r route) equal(other route) bool {
+	if r.Master != other.Master || len(r.Replicas) != len(other.Replicas) {
+		return false
+	}
+	a := append([]string(nil), r.Replicas...)
+	b := append([]string(nil), other.Replicas...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// router resolves the brokers of a key in memory with consistent hashing. A key is
+// held by the first brokers following it on a ring of every configured broker, unless
+// a migration or a failover moved it, in which case its route is stored as an override.
+// The ring is versioned in the database so a change of the brokers doesn't silently
+// move keys away from their messages.
+type router struct {
+	mu        sync.RWMutex
+	ring      *hashRing
+	members   []string
+	weights   []float64
+	vnodes    int
+	replicas  int
+	version   int
+	overrides map[string]route
+}
+
+func newRouter(members []string, weights map[string]float64, vnodes, replicas int) *router {
+	members = append([]string(nil), members...)
+	sort.Strings(members)
+	if replicas <= 0 {
+		replicas = 1
+	}
+	memberWeights := make([]float64, len(members))
+	for i, name := range members {
+		memberWeights[i] = 1
+		if w, ok := weights[name]; ok && w > 0 {
+			memberWeights[i] = w
+		}
+	}
+	return &router{
+		ring:      newHashRing(members, weights, vnodes),
+		members:   members,
+		weights:   memberWeights,
+		vnodes:    vnodes,
+		replicas:  replicas,
+		overrides: map[string]route{},
+	}
+}
+
+// ringRoute returns the route of the key on the ring, ignoring overrides
+func (r *router) ringRoute(key string) route {
+	brokers := r.ring.walk(key)
+	if len(brokers) > r.replicas {
+		brokers = brokers[:r.replicas]
+	}
+	if len(brokers) == 0 {
+		return route{}
+	}
+	return route{Master: brokers[0], Replicas: brokers[1:]}
+}
+
+// resolve returns the route of the key
+func (r *router) resolve(key string) route {
+	r.mu.RLock()
+	override, ok := r.overrides[key]
+	r.mu.RUnlock()
+	if ok {
+		return override
+	}
+	return r.ringRoute(key)
+}
+
+func (r *router) setOverride(key string, rt *route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rt == nil {
+		delete(r.overrides, key)
+		return
+	}
+	r.overrides[key] = *rt
+}
+
+// routingFromConfig returns a router if routing is set to consistent_hash
+func routingFromConfig(members []string, weights map[string]float64, replicas int) *router {
+	switch mode := viper.GetString("routing"); mode {
+	case "", RoutingTable:
+		return nil
+	case RoutingConsistentHash:
+		return newRouter(members, weights, ringVnodes(), replicas)
+	default:
+		log.Warnf("unknown routing mode %q, using %s", mode, RoutingTable)
+		return nil
+	}
+}
+
+// routeRefreshInterval returns how often the overrides written by other zookeepers
+// are loaded
+func routeRefreshInterval() time.Duration {
+	d := viper.GetDuration("route_refresh_interval")
+	if d <= 0 {
+		d = 5 * time.Second
+	}
+	return d
+}
+
+// initRouter stores the ring of the router as a new version if it differs from the
+// latest one, pinning every key whose route changes to its current brokers, and loads
+// the overrides
+func (s *Zookeeper) initRouter() error {
+	r := s.router
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", ringLockID); err != nil {
+		return err
+	}
+	var version, vnodes, replicas int
+	var members []string
+	var weights []float64
+	err = tx.QueryRow("SELECT version, members, weights, vnodes, replicas FROM routing_rings ORDER BY version DESC LIMIT 1").
+		Scan(&version, pq.Array(&members), pq.Array(&weights), &vnodes, &replicas)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	same := err == nil && vnodes == r.vnodes && replicas == r.replicas &&
+		fmt.Sprint(members) == fmt.Sprint(r.members) && fmt.Sprint(weights) == fmt.Sprint(r.weights)
+	if same {
+		r.version = version
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return s.loadOverrides()
+	}
+
+	r.version = version + 1
+	_, err = tx.Exec("INSERT INTO routing_rings (version, members, weights, vnodes, replicas) VALUES ($1, $2, $3, $4, $5)",
+		r.version, pq.Array(r.members), pq.Array(r.weights), r.vnodes, r.replicas)
+	if err != nil {
+		return err
+	}
+	pinned, err := s.pinRoutes(tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"version": r.version,
+		"members": r.members,
+		"weights": r.weights,
+		"vnodes":  r.vnodes,
+		"pinned":  pinned,
+	}).Info("Stored new ring version")
+	return s.loadOverrides()
+}
+
+// pinRoutes rewrites the overrides so every assigned key keeps its current brokers on
+// the ring of the router. It returns the number of overrides.
+func (s *Zookeeper) pinRoutes(tx *sql.Tx) (int, error) {
+	keys, err := s.keyAssignments(anyKey)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM route_overrides"); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, k := range keys {
+		current := route{Master: k.Master, Replicas: k.Replicas}
+		if current.equal(s.router.ringRoute(k.Key)) {
+			continue
+		}
+		_, err := tx.Exec("INSERT INTO route_overrides (queue, master, replicas, ring_version) VALUES ($1, $2, $3, $4)",
+			k.Key, current.Master, pq.Array(current.Replicas), s.router.version)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// loadOverrides replaces the overrides of the router with the ones in the database
+func (s *Zookeeper) loadOverrides() error {
+	rows, err := s.db.Query("SELECT queue, master, replicas FROM route_overrides")
+	if err != nil {
+		log.Warnf("Couldn't load route overrides: %s", err.Error())
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Warnf("Couldn't close rows: %s", err.Error())
+		}
+	}(rows)
+
+	overrides := map[string]route{}
+	for rows.Next() {
+		var key string
+		var rt route
+		if err := rows.Scan(&key, &rt.Master, pq.Array(&rt.Replicas)); err != nil {
+			return err
+		}
+		overrides[key] = rt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.router.mu.Lock()
+	s.router.overrides = overrides
+	s.router.mu.Unlock()
+	return nil
+}
+
+// RouteRefresher periodically loads the overrides written by other zookeepers and
+// warns if another zookeeper stored a newer ring
+func (s *Zookeeper) RouteRefresher() {
+	ticker := time.NewTicker(routeRefreshInterval())
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.loadOverrides(); err != nil {
+				continue
+			}
+			var version int
+			err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM routing_rings").Scan(&version)
+			if err != nil {
+				log.Warnf("Couldn't get ring version: %s", err.Error())
+				continue
+			}
+			if version != s.router.version {
+				log.WithFields(log.Fields{
+					"version": s.router.version,
+					"latest":  version,
+				}).Warn("Ring version changed by another zookeeper, brokers must be configured the same everywhere")
+			}
+		}
+	}
+}
+
+// pinRoute stores the brokers currently assigned to the key as its override if they
+// differ from its route on the ring, and drops its override otherwise
+func (s *Zookeeper) pinRoute(key string) error {
+	if s.router == nil {
+		return nil
+	}
+	assignments, err := s.keyAssignments(key)
+	if err != nil {
+		return err
+	}
+	var current *route
+	for _, k := range assignments {
+		if k.Key == key {
+			current = &route{Master: k.Master, Replicas: k.Replicas}
+		}
+	}
+
+	if current == nil || current.equal(s.router.ringRoute(key)) {
+		_, err = s.db.Exec("DELETE FROM route_overrides WHERE queue = $1", key)
+		current = nil
+	} else {
+		_, err = s.db.Exec(`INSERT INTO route_overrides (queue, master, replicas, ring_version) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (queue) DO UPDATE SET master = $2, replicas = $3, ring_version = $4, updated_at = now()`,
+			key, current.Master, pq.Array(current.Replicas), s.router.version)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't store route override: %s", err.Error())
+		return err
+	}
+	s.router.setOverride(key, current)
+	return nil
+}
+
+// resolveBrokers returns the master and the replica brokers of the key routed by the
+// ring and the overrides
+func (s *Zookeeper) resolveBrokers(key string) (*broker.Client, []*broker.Client) {
+	rt := s.router.resolve(key)
+	replicas := []*broker.Client{}
+	for _, name := range rt.Replicas {
+		if b := s.brokers[name]; b != nil {
+			replicas = append(replicas, b)
+		}
+	}
+	return s.brokers[rt.Master], replicas
+}
+
+// retryUnassigned calls fn, which sends keys to b. With consistent hash routing a key
+// is only assigned when a broker first reports it doesn't hold it, so fn is called
+// again once the keys are assigned.
+func (s *Zookeeper) retryUnassigned(b *broker.Client, keys []string, fn func() error) error {
+	err := fn()
+	if s.router == nil || !errors.Is(err, errKeyNotFound) {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.assignRoute(key, b); err != nil {
+			return err
+		}
+	}
+	return fn()
+}
+
+// assignRoute adds the key to b if the key is assigned to it, and assigns the key
+// along the ring if it isn't assigned at all
+func (s *Zookeeper) assignRoute(key string, b *broker.Client) error {
+	held, isMaster, err := s.holdsKey(key, b)
+	if err != nil {
+		return err
+	}
+	if held {
+		log.WithFields(log.Fields{
+			"key":    key,
+			"broker": b.Name,
+		}).Info("Adding missing key to broker")
+		if err := b.AddKey(key, isMaster); err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": b.Name,
+			}).Warnf("Couldn't add key to broker: %s", err.Error())
+		}
+		return nil
+	}
+
+	assignments, err := s.keyAssignments(key)
+	if err != nil {
+		return err
+	}
+	for _, k := range assignments {
+		if k.Key == key {
+			// Assigned to other brokers by another zookeeper
+			return s.pinRoute(key)
+		}
+	}
+	return s.AssignKey(key)
+}
+
+// routingStatus returns the ring of the router and its overrides
+func (s *Zookeeper) routingStatus() *types.RoutingResponse {
+	if s.router == nil {
+		return &types.RoutingResponse{Mode: RoutingTable}
+	}
+	s.router.mu.RLock()
+	defer s.router.mu.RUnlock()
+	return &types.RoutingResponse{
+		Mode:      RoutingConsistentHash,
+		Version:   s.router.version,
+		Members:   s.router.members,
+		Vnodes:    s.router.vnodes,
+		Overrides: len(s.router.overrides),
+	}
+}
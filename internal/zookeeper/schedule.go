@@ -0,0 +1,250 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// scheduledBatchSize is the maximum number of due messages delivered in a scheduler tick
+const scheduledBatchSize = 100
+
+// scheduleElement sets the delivery time of a message from the delay or the
+// deliver_at field of its push request
+func scheduleElement(elem *types.Element, req *types.PushRequest) error {
+	if req.Delay != "" && req.DeliverAt != nil {
+		return errors.New("only one of delay and deliver_at can be set")
+	}
+	if req.Delay != "" {
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("delay must not be negative")
+		}
+		deliverAt := elem.Timestamp.Add(d)
+		elem.DeliverAt = &deliverAt
+	}
+	if req.DeliverAt != nil {
+		deliverAt := req.DeliverAt.UTC()
+		elem.DeliverAt = &deliverAt
+	}
+	return nil
+}
+
+// scheduled reports whether a message must be held back until its delivery time
+func scheduled(elem *types.Element) bool {
+	return elem.DeliverAt != nil && elem.DeliverAt.After(time.Now())
+}
+
+// schedule stores a delayed message in the database until it is due. Scheduled
+// messages don't live on any broker, so they survive zookeeper restarts, broker
+// failures and key migrations, and are routed to the brokers owning the key when due.
+func (s *Zookeeper) schedule(elem *types.Element) error {
+	return s.scheduleAt(elem, *elem.DeliverAt)
+}
+
+// scheduleAt stores a message in the database until the given time
+func (s *Zookeeper) scheduleAt(elem *types.Element, at time.Time) error {
+	data, err := json.Marshal(elem)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO scheduled_messages (id, queue, message, deliver_at) VALUES ($1, $2, $3, $4)", elem.ID, elem.Key, string(data), at)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": elem.Key,
+			"id":  elem.ID,
+		}).Warnf("Couldn't store scheduled message in database: %s", err.Error())
+		return err
+	}
+	log.WithFields(log.Fields{
+		"key":        elem.Key,
+		"id":         elem.ID,
+		"deliver_at": at,
+	}).Info("Scheduled message")
+	return nil
+}
+
+// submitElement pushes a message to the brokers of its key, or schedules it if its
+// delivery time is in the future
+func (s *Zookeeper) submitElement(elem *types.Element) error {
+	if scheduled(elem) {
+		return s.schedule(elem)
+	}
+	return s.pushElement(elem)
+}
+
+func schedulerInterval() time.Duration {
+	d := viper.GetDuration("scheduler_interval")
+	if d <= 0 {
+		return time.Second
+	}
+	return d
+}
+
+// scheduledClaimTimeout is how long a zookeeper may hold a due scheduled message while
+// pushing it before another zookeeper delivers it, in case the first one stopped
+func scheduledClaimTimeout() time.Duration {
+	d := viper.GetDuration("scheduler_claim_timeout")
+	if d <= 0 {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// Scheduler periodically pushes the scheduled messages that are due to their brokers.
+// Rows are claimed with SKIP LOCKED, so several zookeepers can run it at once.
+func (s *Zookeeper) Scheduler() {
+	ticker := time.NewTicker(schedulerInterval())
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.deliverScheduled()
+			if err != nil {
+				log.Warnf("Couldn't deliver scheduled messages: %s", err.Error())
+			}
+		}
+	}
+}
+
+// deliverScheduled delivers the scheduled messages that are due. Every message is
+// claimed until a deadline before it is pushed and only deleted from the database
+// once its master has it, so a message whose zookeeper stops halfway is delivered by
+// another one after the deadline.
+func (s *Zookeeper) deliverScheduled() error {
+	for i := 0; i < scheduledBatchSize; i++ {
+		elem, claim, err := s.claimScheduled()
+		if elem == nil || err != nil {
+			return err
+		}
+		s.deliverDue(elem, claim)
+	}
+	return nil
+}
+
+// claimScheduled claims the earliest due scheduled message that isn't claimed by
+// another delivery and returns it with the end of its claim. A message that can't be
+// decoded is moved to scheduled_messages_failed instead. It returns nil if no message
+// is due.
+func (s *Zookeeper) claimScheduled() (*types.Element, time.Time, error) {
+	for {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		var id, key string
+		var data []byte
+		err = tx.QueryRow(`SELECT id, queue, message FROM scheduled_messages
+			WHERE deliver_at <= now() AND (claimed_until IS NULL OR claimed_until < now())
+			ORDER BY deliver_at LIMIT 1 FOR UPDATE SKIP LOCKED`).
+			Scan(&id, &key, &data)
+		if errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			return nil, time.Time{}, nil
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, time.Time{}, err
+		}
+
+		elem := &types.Element{}
+		var claim time.Time
+		decodeErr := json.Unmarshal(data, elem)
+		if decodeErr != nil {
+			log.WithFields(log.Fields{
+				"key": key,
+				"id":  id,
+			}).Warnf("Couldn't decode scheduled message, moving it aside: %s", decodeErr.Error())
+			_, err = tx.Exec("INSERT INTO scheduled_messages_failed (id, queue, message, error) VALUES ($1, $2, $3, $4)", id, key, string(data), decodeErr.Error())
+			if err == nil {
+				_, err = tx.Exec("DELETE FROM scheduled_messages WHERE id = $1", id)
+			}
+		} else {
+			err = tx.QueryRow("UPDATE scheduled_messages SET claimed_until = now() + $2 * interval '1 millisecond' WHERE id = $1 RETURNING claimed_until",
+				id, scheduledClaimTimeout().Milliseconds()).Scan(&claim)
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, time.Time{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, time.Time{}, err
+		}
+		if decodeErr == nil {
+			return elem, claim, nil
+		}
+	}
+}
+
+// deliverDue pushes a claimed scheduled message to the brokers of its key, applying
+// the overflow policy of the key now that the message becomes visible, and deletes it
+// from the database once delivered. A message that couldn't be pushed is released for
+// the next tick, and is delivered after its claim anyway if releasing it fails.
+func (s *Zookeeper) deliverDue(elem *types.Element, claim time.Time) {
+	adm := newAdmission()
+	err := s.applyOverflow(elem, adm)
+	if errors.Is(err, errQueueFull) {
+		log.WithFields(log.Fields{
+			"key": elem.Key,
+			"id":  elem.ID,
+		}).Warn("Dropping scheduled message, key is full")
+		if s.finishScheduled(elem, claim) {
+			removeBlob(elem)
+		}
+		return
+	}
+	if err == nil {
+		err = s.pushElement(elem)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": elem.Key,
+			"id":  elem.ID,
+		}).Warnf("Couldn't deliver scheduled message: %s", err.Error())
+		_, err := s.db.Exec("UPDATE scheduled_messages SET claimed_until = NULL, deliver_at = $3 WHERE id = $1 AND claimed_until = $2",
+			elem.ID, claim, time.Now().Add(schedulerInterval()))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key": elem.Key,
+				"id":  elem.ID,
+			}).Warnf("Couldn't release undelivered scheduled message: %s", err.Error())
+		}
+		return
+	}
+	s.finishScheduled(elem, claim)
+	s.evictOldest(adm, elem)
+	log.WithFields(log.Fields{
+		"key": elem.Key,
+		"id":  elem.ID,
+	}).Info("Delivered scheduled message")
+}
+
+// finishScheduled deletes a delivered scheduled message if it is still claimed by the
+// delivery. It reports whether the message was deleted. A message that stays stored
+// is delivered again once its claim ends.
+func (s *Zookeeper) finishScheduled(elem *types.Element, claim time.Time) bool {
+	res, err := s.db.Exec("DELETE FROM scheduled_messages WHERE id = $1 AND claimed_until = $2", elem.ID, claim)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": elem.Key,
+			"id":  elem.ID,
+		}).Warnf("Couldn't delete delivered scheduled message, it will be delivered again: %s", err.Error())
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.WithFields(log.Fields{
+			"key": elem.Key,
+			"id":  elem.ID,
+		}).Warn("Scheduled message was claimed by another delivery after its claim ended")
+		return false
+	}
+	return true
+}
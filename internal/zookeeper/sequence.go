@@ -0,0 +1,227 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/broker"
+	"Zookeeper/internal/types"
+	"errors"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// assignSeqs assigns the next sequence numbers of their keys to the messages, in the
+// order of the messages. Sequence numbers start at 1 and increase by one for every
+// message pushed to a key. They are stored before the messages reach any broker, so a
+// number is never handed out twice even if the zookeeper stops; a push that fails
+// afterwards leaves a gap, which consumers and contiguousSeq can tell apart. Keys are
+// locked in lexical order so concurrent pushes to several keys can't deadlock.
+func (s *Zookeeper) assignSeqs(elems []*types.Element) error {
+	count := map[string]int64{}
+	for _, elem := range elems {
+		count[elem.Key]++
+	}
+	keys := make([]string, 0, len(count))
+	for key := range count {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	next := map[string]int64{}
+	for _, key := range keys {
+		var last int64
+		err := tx.QueryRow(`INSERT INTO key_sequences (queue, seq) VALUES ($1, $2)
+			ON CONFLICT (queue) DO UPDATE SET seq = key_sequences.seq + $2 RETURNING seq`, key, count[key]).Scan(&last)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key": key,
+			}).Warnf("Couldn't assign sequence number: %s", err.Error())
+			return err
+		}
+		next[key] = last - count[key] + 1
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		elem.Seq = next[elem.Key]
+		next[elem.Key]++
+	}
+	return nil
+}
+
+// contiguousSeq returns the highest sequence number reached from the lowest one of
+// the messages without a gap, or 0 if none has a sequence number
+func contiguousSeq(messages []types.Element) int64 {
+	seqs := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		if m.Seq > 0 {
+			seqs = append(seqs, m.Seq)
+		}
+	}
+	if len(seqs) == 0 {
+		return 0
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	high := seqs[0]
+	for _, seq := range seqs[1:] {
+		if seq > high+1 {
+			break
+		}
+		high = seq
+	}
+	return high
+}
+
+// promoteReplica chooses the replica of the key with the highest contiguous sequence
+// number to become its master. Gaps in its messages are backfilled from the other
+// replicas, which are then brought in sync with it.
+func (s *Zookeeper) promoteReplica(key string, replicas []*broker.Client) (*broker.Client, error) {
+	exports := map[string][]types.Element{}
+	for _, b := range replicas {
+		if !b.Health {
+			continue
+		}
+		res, err := b.Export(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": b.Name,
+			}).Warnf("Couldn't export key from replica: %s", err.Error())
+			continue
+		}
+		exports[b.Name] = res.Messages
+	}
+	name, seq := mostCompleteReplica(exports)
+	if name == "" {
+		return nil, errors.New("no healthy replica brokers found for key")
+	}
+	chosen := s.brokers[name]
+
+	// Brokers add imported messages to the ones they hold, so every broker only gets
+	// the messages it misses
+	messages, backfilled := backfill(name, exports)
+	log.WithFields(log.Fields{
+		"key":        key,
+		"broker":     chosen.Name,
+		"seq":        seq,
+		"backfilled": backfilled,
+	}).Info("Chose replica to promote")
+	if backfilled > 0 {
+		if err := chosen.Import(key, true, missingSeqs(exports[name], messages)); err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": chosen.Name,
+			}).Warnf("Couldn't backfill replica: %s", err.Error())
+			return nil, err
+		}
+	}
+
+	for name, others := range exports {
+		if name == chosen.Name || sameSeqs(others, messages) {
+			continue
+		}
+		missing := missingSeqs(others, messages)
+		if len(missing) == 0 {
+			continue
+		}
+		if err := s.brokers[name].Import(key, false, missing); err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": name,
+			}).Warnf("Couldn't resync replica: %s", err.Error())
+		}
+	}
+	return chosen, nil
+}
+
+// mostCompleteReplica returns the replica whose exported messages have the highest
+// contiguous sequence number, the first by name on a tie, and that number. It returns
+// an empty name if there are no exports.
+func mostCompleteReplica(exports map[string][]types.Element) (string, int64) {
+	names := make([]string, 0, len(exports))
+	for name := range exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	chosen, chosenSeq := "", int64(-1)
+	for _, name := range names {
+		if seq := contiguousSeq(exports[name]); seq > chosenSeq {
+			chosen, chosenSeq = name, seq
+		}
+	}
+	return chosen, chosenSeq
+}
+
+// backfill returns the messages of the chosen replica completed with the messages of
+// the other replicas it misses, in sequence order, and the number of added messages.
+// Messages below the lowest sequence number of the chosen replica were already
+// acknowledged and aren't added. Sequence numbers follow the push order of the key,
+// so sorting by them keeps it.
+func backfill(chosen string, exports map[string][]types.Element) ([]types.Element, int) {
+	messages := append([]types.Element(nil), exports[chosen]...)
+	low := int64(0)
+	present := map[int64]bool{}
+	for _, m := range messages {
+		if m.Seq > 0 && (low == 0 || m.Seq < low) {
+			low = m.Seq
+		}
+		present[m.Seq] = true
+	}
+	added := 0
+	for name, others := range exports {
+		if name == chosen {
+			continue
+		}
+		for _, m := range others {
+			if m.Seq > low && !present[m.Seq] {
+				messages = append(messages, m)
+				present[m.Seq] = true
+				added++
+			}
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Seq < messages[j].Seq })
+	return messages, added
+}
+
+// missingSeqs returns the messages of want whose sequence number none of the messages
+// of have holds, in the order of want. Messages without a sequence number can't be
+// matched and are never missing.
+func missingSeqs(have, want []types.Element) []types.Element {
+	present := map[int64]bool{}
+	for _, m := range have {
+		present[m.Seq] = true
+	}
+	var res []types.Element
+	for _, m := range want {
+		if m.Seq > 0 && !present[m.Seq] {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// sameSeqs reports whether two lists of messages hold the same sequence numbers
+func sameSeqs(a, b []types.Element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seqs := map[int64]int{}
+	for _, m := range a {
+		seqs[m.Seq]++
+	}
+	for _, m := range b {
+		seqs[m.Seq]--
+		if seqs[m.Seq] < 0 {
+			return false
+		}
+	}
+	return true
+}
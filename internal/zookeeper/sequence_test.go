@@ -0,0 +1,151 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"reflect"
+	"testing"
+)
+
+// elems returns messages with the sequence numbers
+func elems(seqs ...int64) []types.Element {
+	messages := make([]types.Element, 0, len(seqs))
+	for _, seq := range seqs {
+		messages = append(messages, types.Element{Seq: seq})
+	}
+	return messages
+}
+
+func seqsOf(messages []types.Element) []int64 {
+	seqs := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		seqs = append(seqs, m.Seq)
+	}
+	return seqs
+}
+
+func TestContiguousSeq(t *testing.T) {
+	tests := []struct {
+		seqs []int64
+		want int64
+	}{
+		{nil, 0},
+		{[]int64{0, 0}, 0},
+		{[]int64{1}, 1},
+		{[]int64{1, 2, 3}, 3},
+		{[]int64{3, 1, 2}, 3},
+		{[]int64{4, 5, 7, 8}, 5},
+		{[]int64{1, 1, 2}, 2},
+		{[]int64{0, 2, 3}, 3},
+	}
+	for _, tt := range tests {
+		if got := contiguousSeq(elems(tt.seqs...)); got != tt.want {
+			t.Errorf("contiguousSeq(%v) = %d, want %d", tt.seqs, got, tt.want)
+		}
+	}
+}
+
+func TestSameSeqs(t *testing.T) {
+	tests := []struct {
+		a, b []int64
+		want bool
+	}{
+		{nil, nil, true},
+		{[]int64{1, 2}, []int64{2, 1}, true},
+		{[]int64{1, 2}, []int64{1, 2, 3}, false},
+		{[]int64{1, 2}, []int64{1, 3}, false},
+		{[]int64{1, 1}, []int64{1, 2}, false},
+	}
+	for _, tt := range tests {
+		if got := sameSeqs(elems(tt.a...), elems(tt.b...)); got != tt.want {
+			t.Errorf("sameSeqs(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMostCompleteReplica(t *testing.T) {
+	tests := []struct {
+		exports  map[string][]types.Element
+		wantName string
+		wantSeq  int64
+	}{
+		{map[string][]types.Element{}, "", -1},
+		{map[string][]types.Element{"b1": elems(1, 2, 4), "b2": elems(1, 2, 3)}, "b2", 3},
+		{map[string][]types.Element{"b2": elems(5, 6), "b1": elems(5, 6)}, "b1", 6},
+		{map[string][]types.Element{"b1": elems(), "b2": elems(2)}, "b2", 2},
+		{map[string][]types.Element{"b1": elems()}, "b1", 0},
+	}
+	for _, tt := range tests {
+		name, seq := mostCompleteReplica(tt.exports)
+		if name != tt.wantName || seq != tt.wantSeq {
+			t.Errorf("mostCompleteReplica(%v) = %s, %d, want %s, %d", tt.exports, name, seq, tt.wantName, tt.wantSeq)
+		}
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	tests := []struct {
+		name      string
+		exports   map[string][]types.Element
+		want      []int64
+		wantAdded int
+	}{
+		{
+			name:    "in sync",
+			exports: map[string][]types.Element{"b1": elems(3, 4), "b2": elems(3, 4)},
+			want:    []int64{3, 4},
+		},
+		{
+			name:      "gaps filled in sequence order",
+			exports:   map[string][]types.Element{"b1": elems(3, 4, 7), "b2": elems(5, 6), "b3": elems(6, 8)},
+			want:      []int64{3, 4, 5, 6, 7, 8},
+			wantAdded: 3,
+		},
+		{
+			name:    "acknowledged messages not added back",
+			exports: map[string][]types.Element{"b1": elems(5, 6), "b2": elems(3, 4, 5, 6)},
+			want:    []int64{5, 6},
+		},
+		{
+			name:      "empty replica",
+			exports:   map[string][]types.Element{"b1": elems(), "b2": elems(1, 2)},
+			want:      []int64{1, 2},
+			wantAdded: 2,
+		},
+	}
+	for _, tt := range tests {
+		exports := map[string][]types.Element{}
+		for name, messages := range tt.exports {
+			exports[name] = append(make([]types.Element, 0, len(messages)), messages...)
+		}
+		messages, added := backfill("b1", tt.exports)
+		if got := seqsOf(messages); !reflect.DeepEqual(got, tt.want) || added != tt.wantAdded {
+			t.Errorf("%s: backfill() = %v, %d, want %v, %d", tt.name, got, added, tt.want, tt.wantAdded)
+		}
+		if !reflect.DeepEqual(tt.exports, exports) {
+			t.Errorf("%s: backfill() modified the exports", tt.name)
+		}
+	}
+}
+
+func TestMissingSeqs(t *testing.T) {
+	tests := []struct {
+		have, want []int64
+		missing    []int64
+	}{
+		{nil, nil, nil},
+		{[]int64{1, 2}, []int64{1, 2}, nil},
+		{[]int64{3, 5}, []int64{3, 4, 5, 6}, []int64{4, 6}},
+		{nil, []int64{1, 2}, []int64{1, 2}},
+		{[]int64{1, 2, 3}, []int64{2}, nil},
+		{nil, []int64{0, 1}, []int64{1}},
+	}
+	for _, tt := range tests {
+		got := seqsOf(missingSeqs(elems(tt.have...), elems(tt.want...)))
+		if len(got) == 0 && len(tt.missing) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.missing) {
+			t.Errorf("missingSeqs(%v, %v) = %v, want %v", tt.have, tt.want, got, tt.missing)
+		}
+	}
+}
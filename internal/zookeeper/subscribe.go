@@ -0,0 +1,173 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// subscribeKeepAlive is the interval of the keep-alive comments sent on idle streams.
+// Outstanding leases are checked again on every keep-alive as well.
+const subscribeKeepAlive = 15 * time.Second
+
+// Subscribe streams leased messages of the keys in the keys query parameter to the
+// client as Server-Sent Events, or messages of any key if it is empty. Keys may be
+// glob patterns such as orders.*. The prefetch
+// query parameter is the credit of the stream: the number of delivered messages that
+// may be unacknowledged at once. A credit is given back when a message is acked,
+// nacked or its lease expires.
+func (s *Zookeeper) Subscribe(c *gin.Context) {
+	var keys []string
+	for _, key := range strings.Split(c.Query("keys"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	prefetch := viper.GetInt("subscribe_prefetch")
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+	if value := c.Query("prefetch"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			fail(c, invalid(errors.New("prefetch must be a positive integer")))
+			return
+		}
+		prefetch = n
+	}
+
+	log.WithFields(log.Fields{
+		"keys":     keys,
+		"prefetch": prefetch,
+	}).Info("Client subscribed")
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	s.consume(c.Request.Context(), keys, prefetch, func(d *delivery, err error) error {
+		if err == nil {
+			err = loadValue(d.Element)
+		}
+		if err != nil {
+			c.SSEvent("error", errorBody(c, err, nil))
+		} else if isLegacy(c) {
+			c.SSEvent("message", legacyDelivery(newDelivery(d)))
+		} else {
+			c.SSEvent("message", newDelivery(d))
+		}
+		c.Writer.Flush()
+		return nil
+	}, func() {
+		_, _ = c.Writer.WriteString(": keep-alive\n\n")
+		c.Writer.Flush()
+	})
+	log.WithFields(log.Fields{
+		"keys": keys,
+	}).Info("Client unsubscribed")
+}
+
+// consume leases messages of the keys, or of any key if keys is empty, and hands them
+// to send until the context is done or send fails. At most prefetch delivered messages
+// are unacknowledged at once. Pop errors are handed to send as well, and keepAlive is
+// called when the stream is idle.
+func (s *Zookeeper) consume(ctx context.Context, keys []string, prefetch int, send func(d *delivery, err error) error, keepAlive func()) error {
+	topics := keys
+	if len(topics) == 0 {
+		topics = []string{anyKey}
+	}
+	pushes := s.notifier.Subscribe(topics...)
+	defer s.notifier.Unsubscribe(pushes, topics...)
+	acks := s.acks.Subscribe(anyKey)
+	defer s.acks.Unsubscribe(acks, anyKey)
+	ticker := time.NewTicker(subscribeKeepAlive)
+	defer ticker.Stop()
+
+	var outstanding []string
+	next := 0
+	for {
+		if len(outstanding) >= prefetch {
+			var err error
+			outstanding, err = s.outstandingLeases(outstanding)
+			if err != nil {
+				log.Warnf("Couldn't check outstanding leases: %s", err.Error())
+			}
+		}
+
+		if len(outstanding) < prefetch {
+			d, err := s.popSubscribed(keys, next)
+			next++
+			if err != nil {
+				if err := send(nil, err); err != nil {
+					return err
+				}
+			}
+			if d != nil {
+				outstanding = append(outstanding, d.ID)
+				if err := send(d, nil); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		select {
+		case <-pushes:
+		case <-acks:
+		case <-ticker.C:
+			keepAlive()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// popSubscribed leases a message from the first non-empty key, starting from the key
+// at offset so that every key gets its turn. Patterns are served by the pop policy
+// among their matching keys. Keys without brokers yet are skipped.
+func (s *Zookeeper) popSubscribed(keys []string, offset int) (*delivery, error) {
+	if len(keys) == 0 {
+		return s.popAny()
+	}
+	for i := range keys {
+		key := keys[(offset+i)%len(keys)]
+		d, err := s.popper(key)()
+		if errors.Is(err, errKeyNotFound) {
+			continue
+		}
+		if d != nil || err != nil {
+			return d, err
+		}
+	}
+	return nil, nil
+}
+
+// outstandingLeases returns the leases of ids that are neither acknowledged nor expired
+func (s *Zookeeper) outstandingLeases(ids []string) ([]string, error) {
+	rows, err := s.db.Query("SELECT id FROM leases WHERE id = ANY($1) AND deadline > now()", pq.Array(ids))
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,342 @@
+package zookeeper
+
+import (
+	"Zookeeper/internal/types"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var errTxAborted = errors.New("transaction aborted")
+
+// States of a transaction. Finished transactions are deleted.
+const (
+	txPreparing  = "preparing"
+	txCommitting = "committing"
+	txAborting   = "aborting"
+)
+
+// txTimeout is how long a transaction may stay unfinished before the recoverer takes
+// it over: a preparing transaction is aborted, a committing or aborting one finished
+func txTimeout() time.Duration {
+	d := viper.GetDuration("tx_timeout")
+	if d <= 0 {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// txAbandonAfter is how long a finished transaction waits for a participant whose
+// broker was removed from the configuration before giving up on it
+func txAbandonAfter() time.Duration {
+	d := viper.GetDuration("tx_abandon_after")
+	if d <= 0 {
+		return 10 * txTimeout()
+	}
+	return d
+}
+
+// pushTransaction pushes messages to several keys as one unit with a two-phase commit.
+// Every master and replica of the keys stages its messages first; the transaction
+// commits only once all of them did, and aborts otherwise. The commit decision is
+// stored in the database before any broker commits, so the recoverer finishes the
+// transaction if the zookeeper stops halfway.
+func (s *Zookeeper) pushTransaction(reqs []types.PushRequest) (string, []types.PushResult, error) {
+	elems := make([]*types.Element, len(reqs))
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		for _, elem := range elems {
+			removeBlob(elem)
+		}
+	}()
+	adm := newAdmission()
+	for index := range reqs {
+		req := &reqs[index]
+		if req.Delay != "" || req.DeliverAt != nil {
+			return "", nil, invalid(fmt.Errorf("message %d: delayed messages can't be pushed in a transaction", index))
+		}
+		if req.IdempotencyKey != "" {
+			return "", nil, invalid(fmt.Errorf("message %d: idempotency keys aren't supported in a transaction", index))
+		}
+		elem, err := s.prepareElement(req, adm)
+		if err != nil {
+			return "", nil, fmt.Errorf("message %d: %w", index, err)
+		}
+		elems[index] = elem
+	}
+
+	for _, elem := range elems {
+		if err := s.EnsureKeyAssigned(elem.Key); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := s.assignSeqs(elems); err != nil {
+		return "", nil, err
+	}
+
+	batches := make(map[string][]types.Element)
+	for _, elem := range elems {
+		for _, b := range s.GetBrokers(elem.Key) {
+			batches[b.Name] = append(batches[b.Name], *elem)
+		}
+	}
+
+	id := newID()
+	if err := s.beginTransaction(id, batches); err != nil {
+		return "", nil, err
+	}
+	for name, batch := range batches {
+		log.WithFields(log.Fields{
+			"tx":     id,
+			"broker": name,
+			"count":  len(batch),
+		}).Info("Preparing transaction on broker")
+		b := s.brokers[name]
+		keys := make([]string, 0, len(batch))
+		for _, elem := range batch {
+			keys = append(keys, elem.Key)
+		}
+		err := s.retryUnassigned(b, keys, func() error {
+			return b.TxPrepare(id, batch)
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"tx":     id,
+				"broker": name,
+			}).Warnf("Couldn't prepare transaction on broker: %s", err.Error())
+			s.abortTransaction(id)
+			return "", nil, fmt.Errorf("%w: %s", errTxAborted, err.Error())
+		}
+	}
+
+	res, err := s.db.Exec("UPDATE transactions SET state = $2, updated_at = now() WHERE id = $1 AND state = $3", id, txCommitting, txPreparing)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"tx": id,
+		}).Warnf("Couldn't store commit decision: %s", err.Error())
+		s.abortTransaction(id)
+		return "", nil, fmt.Errorf("%w: %s", errTxAborted, err.Error())
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The recoverer aborted the transaction because preparing took too long
+		return "", nil, errTxAborted
+	}
+	committed = true
+	if err := s.finishTransaction(id, txCommitting); err != nil {
+		log.WithFields(log.Fields{
+			"tx": id,
+		}).Warnf("Transaction committed but not on every broker yet: %s", err.Error())
+	}
+
+	// Messages are only evicted to make room once the transaction is committed
+	results := make([]types.PushResult, len(elems))
+	for index, elem := range elems {
+		s.evictOldest(adm, elem)
+		results[index] = types.PushResult{ID: elem.ID, Key: elem.Key, Status: types.StatusOK}
+		s.notifier.Notify(elem.Key)
+	}
+	return id, results, nil
+}
+
+// beginTransaction stores a preparing transaction and its participating brokers
+func (s *Zookeeper) beginTransaction(id string, batches map[string][]types.Element) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO transactions (id, state) VALUES ($1, $2)", id, txPreparing); err != nil {
+		log.WithFields(log.Fields{
+			"tx": id,
+		}).Warnf("Couldn't store transaction: %s", err.Error())
+		return err
+	}
+	for name := range batches {
+		if _, err := tx.Exec("INSERT INTO transaction_participants (tx_id, broker) VALUES ($1, $2)", id, name); err != nil {
+			log.WithFields(log.Fields{
+				"tx":     id,
+				"broker": name,
+			}).Warnf("Couldn't store transaction participant: %s", err.Error())
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// abortTransaction aborts a transaction unless the commit decision was already taken
+func (s *Zookeeper) abortTransaction(id string) {
+	_, err := s.db.Exec("UPDATE transactions SET state = $2, updated_at = now() WHERE id = $1 AND state = $3", id, txAborting, txPreparing)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"tx": id,
+		}).Warnf("Couldn't store abort decision: %s", err.Error())
+		return
+	}
+	if err := s.finishTransaction(id, txAborting); err != nil {
+		log.WithFields(log.Fields{
+			"tx": id,
+		}).Warnf("Transaction aborted but not on every broker yet: %s", err.Error())
+	}
+}
+
+// finishTransaction commits or aborts the transaction on every participant that didn't
+// do it yet, and deletes the transaction once all of them did. A participant whose
+// broker was removed from the configuration is given up on after tx_abandon_after.
+func (s *Zookeeper) finishTransaction(id string, state string) error {
+	rows, err := s.db.Query("SELECT broker FROM transaction_participants WHERE tx_id = $1 AND NOT done", id)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	var failed error
+	for _, name := range names {
+		b := s.brokers[name]
+		if b == nil {
+			// The broker may come back with the staged messages, so it stays pending
+			// until it is given up on
+			var abandon bool
+			err := s.db.QueryRow("SELECT updated_at < now() - make_interval(secs => $2) FROM transactions WHERE id = $1",
+				id, txAbandonAfter().Seconds()).Scan(&abandon)
+			if err != nil {
+				failed = err
+				continue
+			}
+			if !abandon {
+				log.WithFields(log.Fields{
+					"tx":     id,
+					"broker": name,
+					"state":  state,
+				}).Warn("Couldn't finish transaction on unknown broker")
+				failed = fmt.Errorf("unknown broker %s", name)
+				continue
+			}
+			log.WithFields(log.Fields{
+				"tx":     id,
+				"broker": name,
+				"state":  state,
+			}).Errorf("Giving up on finishing transaction on unknown broker after %s, its staged messages must be resolved by hand", txAbandonAfter())
+		} else {
+			if state == txCommitting {
+				err = b.TxCommit(id)
+			} else {
+				err = b.TxAbort(id)
+			}
+			if err != nil {
+				log.WithFields(log.Fields{
+					"tx":     id,
+					"broker": name,
+					"state":  state,
+				}).Warnf("Couldn't finish transaction on broker: %s", err.Error())
+				failed = err
+				continue
+			}
+		}
+		if _, err := s.db.Exec("UPDATE transaction_participants SET done = true WHERE tx_id = $1 AND broker = $2", id, name); err != nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+
+	_, err = s.db.Exec("DELETE FROM transactions WHERE id = $1", id)
+	if err == nil {
+		log.WithFields(log.Fields{
+			"tx":    id,
+			"state": state,
+		}).Info("Finished transaction")
+	}
+	return err
+}
+
+// TransactionRecoverer periodically aborts transactions stuck in the prepare phase and
+// finishes the commits and aborts left over by a failed zookeeper
+func (s *Zookeeper) TransactionRecoverer() {
+	d := viper.GetDuration("tx_recovery_interval")
+	if d <= 0 {
+		d = 10 * time.Second
+	}
+	ticker := time.NewTicker(d)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.recoverTransactions(); err != nil {
+				log.Warnf("Couldn't recover transactions: %s", err.Error())
+			}
+		}
+	}
+}
+
+func (s *Zookeeper) recoverTransactions() error {
+	timeout := txTimeout().Seconds()
+	_, err := s.db.Exec(`UPDATE transactions SET state = $1, updated_at = now()
+		WHERE state = $2 AND updated_at < now() - make_interval(secs => $3)`, txAborting, txPreparing, timeout)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query(`SELECT id, state FROM transactions
+		WHERE state IN ($1, $2) AND updated_at < now() - make_interval(secs => $3)`, txCommitting, txAborting, timeout)
+	if err != nil {
+		return err
+	}
+	pending := map[string]string{}
+	for rows.Next() {
+		var id, state string
+		if err := rows.Scan(&id, &state); err != nil {
+			rows.Close()
+			return err
+		}
+		pending[id] = state
+	}
+	rows.Close()
+
+	for id, state := range pending {
+		log.WithFields(log.Fields{
+			"tx":    id,
+			"state": state,
+		}).Info("Recovering transaction")
+		if err := s.finishTransaction(id, state); err != nil {
+			log.WithFields(log.Fields{
+				"tx": id,
+			}).Warnf("Couldn't recover transaction: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// PushTransaction pushes a list of messages to one or more keys as a single unit:
+// either every message is pushed or none is
+func (s *Zookeeper) PushTransaction(c *gin.Context) {
+	req := &types.TransactionPushRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	id, results, err := s.pushTransaction(req.Messages)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.TransactionPushResponse{ID: id, Results: results})
+}
@@ -2,7 +2,11 @@ package zookeeper
 
 import (
 	"Zookeeper/internal/broker"
+	"Zookeeper/internal/types"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -29,6 +33,10 @@ func (s *Zookeeper) GetMasterBroker(key string) *broker.Client {
 		"key": key,
 	}).Info("Get master broker")
 
+	if s.router != nil {
+		master, _ := s.resolveBrokers(key)
+		return master
+	}
 	rows, err := s.db.Query("SELECT * FROM queues WHERE queue = $1 AND is_master = True", key)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -72,6 +80,10 @@ func (s *Zookeeper) GetReplicaBrokers(key string) []*broker.Client {
 		"key": key,
 	}).Info("Get replica brokers")
 
+	if s.router != nil {
+		_, replicas := s.resolveBrokers(key)
+		return replicas
+	}
 	rows, err := s.db.Query("SELECT * FROM queues WHERE queue = $1 AND is_master = False", key)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -109,18 +121,52 @@ func (s *Zookeeper) GetReplicaBrokers(key string) []*broker.Client {
 	return result
 }
 
-// AssignKey assigns the queueName to a random broker in the cluster as the master
-// and assigns the queueName to the remaining brokers in the cluster as replicas
-// TODO: balance the load better than a simple random
-//
-// TODO: add a replica factor k and add queue to k brokers
+// assignLockClass is the class of the advisory locks serializing the assignment of a
+// key between zookeepers
+const assignLockClass = 1
+
+var errNoBrokers = errors.New("no healthy broker can hold the key")
+
+// AssignKey assigns the key to the brokers chosen by the placement strategy, the
+// first one as master and the others as replicas. The assignment is stored in a
+// transaction holding an advisory lock on the key, so a key assigned concurrently by
+// another zookeeper is left as it is. If a broker or the database fails, the key is
+// removed again from the brokers it was added to. The number of brokers is the
+// replication factor of the key.
 func (s *Zookeeper) AssignKey(key string) error {
 	log.WithFields(log.Fields{
 		"key": key,
 	}).Info("Assign key to a broker")
 
-	brokers := s.GetFreeBrokers(s.replica)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", assignLockClass, key); err != nil {
+		return err
+	}
+	var assigned bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM queues WHERE queue = $1)", key).Scan(&assigned); err != nil {
+		return err
+	}
+	if assigned {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Info("Key was assigned by another zookeeper")
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return s.pinRoute(key)
+	}
+
+	brokers := s.GetFreeBrokers(key, s.replicationFactor(key))
+	if len(brokers) == 0 {
+		return errNoBrokers
+	}
+
+	var added []*broker.Client
 	for index, b := range brokers {
 		var isMaster bool = false
 		if index == 0 {
@@ -140,71 +186,82 @@ func (s *Zookeeper) AssignKey(key string) error {
 				"broker":    b.Name,
 				"is_master": isMaster,
 			}).Warnf("Couldn't add key to broker upstream: %s", err.Error())
+			s.unassignKey(key, added)
 			return err
 		}
+		added = append(added, b)
 
-		_, err = s.db.Exec("INSERT INTO queues (queue, broker, is_master) VALUES ($1, $2, $3)", key, b.Name, isMaster)
+		_, err = tx.Exec("INSERT INTO queues (queue, broker, is_master) VALUES ($1, $2, $3)", key, b.Name, isMaster)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"key":       key,
 				"broker":    b.Name,
 				"is_master": isMaster,
 			}).Warnf("Couldn't add key to database: %s", err.Error())
+			s.unassignKey(key, added)
 			return err
 		}
 	}
-	return nil
-}
-
-func (s *Zookeeper) GetFreeBrokers(count int) []*broker.Client {
-	log.WithFields(log.Fields{
-		"count": count,
-	}).Info("Get free brokers")
-
-	type MinimumLatencyBroker struct {
-		broker  *broker.Client
-		latency time.Duration
+	if err := tx.Commit(); err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't store key assignment: %s", err.Error())
+		s.unassignKey(key, added)
+		return err
 	}
-	var list []MinimumLatencyBroker
+	return s.pinRoute(key)
+}
 
-	for _, b := range s.brokers {
-		if !b.Health {
-			continue
-		}
+// unassignKey removes the key from the brokers it was added to by a failed assignment
+func (s *Zookeeper) unassignKey(key string, brokers []*broker.Client) {
+	for _, b := range brokers {
 		log.WithFields(log.Fields{
-			"broker":  b.Name,
-			"latency": b.Latency,
-		}).Debug("Broker is healthy")
-
-		if len(list) < count {
-			list = append(list, MinimumLatencyBroker{
-				broker:  b,
-				latency: b.Latency,
-			})
-			continue
+			"key":    key,
+			"broker": b.Name,
+		}).Info("Rolling back assignment of key to broker")
+		if err := b.RemoveKey(key); err != nil {
+			log.WithFields(log.Fields{
+				"key":    key,
+				"broker": b.Name,
+			}).Warnf("Couldn't remove key from broker: %s", err.Error())
 		}
+	}
+}
 
-		maximumIndex := -1
-		maximumLatency := time.Duration(0)
-		for index, item := range list {
-			if item.latency < maximumLatency {
-				maximumIndex = index
-				maximumLatency = item.latency
-			}
-		}
-		if maximumLatency > b.Latency {
-			list[maximumIndex] = MinimumLatencyBroker{
-				broker:  b,
-				latency: b.Latency,
-			}
-		}
+// GetFreeBrokers returns up to count brokers to hold a new key, ranked by the placement
+// strategy and spread across failure domains
+func (s *Zookeeper) GetFreeBrokers(key string, count int) []*broker.Client {
+	log.WithFields(log.Fields{
+		"key":      key,
+		"count":    count,
+		"strategy": s.placement.Name(),
+	}).Info("Get free brokers")
+
+	candidates, err := s.placementCandidates()
+	if err != nil {
+		return nil
 	}
 	var res []*broker.Client
-	for _, item := range list {
+	for _, c := range spreadBrokers(s.placement.Rank(key, candidates), count, nil, failureDomains()) {
 		log.WithFields(log.Fields{
-			"broker": item.broker.Name,
+			"broker": c.Broker.Name,
 		}).Info("Selected broker")
-		res = append(res, item.broker)
+		res = append(res, c.Broker)
 	}
 	return res
 }
+
+// newID returns a random identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// stampElement assigns a new ID and the push timestamp to a message
+func stampElement(elem *types.Element) {
+	elem.ID = newID()
+	elem.Timestamp = time.Now().UTC()
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,13 +14,20 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/zsais/go-gin-prometheus"
+	"google.golang.org/grpc"
 )
 
 type Zookeeper struct {
-	gin     *gin.Engine
-	db      *sql.DB
-	brokers map[string]*broker.Client
-	replica int
+	gin       *gin.Engine
+	db        *sql.DB
+	brokers   map[string]*broker.Client
+	replica   int
+	notifier  *notifier
+	acks      *notifier
+	grpc      *grpc.Server
+	popPolicy popPolicy
+	placement PlacementStrategy
+	router    *router
 }
 
 // NewZookeeper returns a new Zookeeper instance
@@ -56,48 +64,88 @@ func NewZookeeper() *Zookeeper {
 	}).Debugf("Connected to database successfully")
 
 	gs := &Zookeeper{
-		gin:     gin.Default(),
-		db:      db,
-		replica: viper.GetInt("replica"),
+		gin:       gin.Default(),
+		db:        db,
+		replica:   viper.GetInt("replica"),
+		notifier:  newNotifier(),
+		acks:      newNotifier(),
+		popPolicy: popPolicyFromConfig(),
 	}
 
 	gs.brokers = make(map[string]*broker.Client)
 	type brokerConfig struct {
-		Name string `yaml:"name" binding:"required"`
-		Host string `yaml:"address" binding:"required"`
+		Name   string            `yaml:"name" binding:"required"`
+		Host   string            `yaml:"address" binding:"required"`
+		Labels map[string]string `yaml:"labels"`
+		Weight float64           `yaml:"weight"`
 	}
 	var brokers []brokerConfig
 	if err := viper.UnmarshalKey("brokers", &brokers); err != nil {
 		log.Fatal(err)
 	}
 
+	names := make([]string, 0, len(brokers))
+	weights := make(map[string]float64, len(brokers))
 	for _, b := range brokers {
+		names = append(names, b.Name)
 		gs.brokers[b.Name] = broker.NewBroker(b.Name, b.Host)
+		gs.brokers[b.Name].Labels = b.Labels
+		if b.Weight > 0 {
+			gs.brokers[b.Name].Weight = b.Weight
+		}
+		weights[b.Name] = gs.brokers[b.Name].Weight
 		go gs.BrokerHealthChecker(gs.brokers[b.Name])
 		log.WithFields(log.Fields{
 			"broker": b.Name,
 			"host":   b.Host,
+			"labels": b.Labels,
+			"weight": gs.brokers[b.Name].Weight,
 		}).Info("Registered broker successfully")
 	}
+	gs.placement = placementStrategyFromConfig(names, weights)
+	gs.router = routingFromConfig(names, weights, gs.replica)
+	if gs.router != nil {
+		if err := gs.initRouter(); err != nil {
+			log.Fatalf("Couldn't initialize the ring: %s", err.Error())
+		}
+		// Keys are placed along the ring so most of them need no override
+		gs.placement = &consistentHashStrategy{ring: gs.router.ring}
+		go gs.RouteRefresher()
+	}
 	go gs.LoadBalancer()
+	go gs.Scheduler()
+	go gs.IdempotencyKeyCleaner()
+	go gs.TransactionRecoverer()
 
 	p := ginprometheus.NewPrometheus("gin")
 	p.Use(gs.gin)
 
 	gs.registerRoutes()
+	gs.registerGRPC()
 	return gs
 }
 
+// registerRoutes registers the endpoints under the v1 prefix and, marked as
+// deprecated, without it
 func (s *Zookeeper) registerRoutes() {
-	s.gin.POST("/pop", s.Pop)
-	s.gin.POST("/push", s.Push)
+	v1 := s.gin.Group(apiVersion)
+	legacy := s.gin.Group("", deprecated(legacySunset()))
+	for _, e := range s.endpoints() {
+		v1.Handle(e.method, e.path, e.handler)
+		legacy.Handle(e.method, e.path, e.handler)
+	}
+	v1.GET("/openapi.json", s.OpenAPI)
+	s.gin.NoRoute(noRoute)
 
 	healthCheckURL := viper.GetString("health_check_path")
 	s.gin.GET(healthCheckURL, s.healthCheck)
 }
 
-// Run runs the Zookeeper server
+// Run runs the Zookeeper server, and the gRPC server if grpc_port is set
 func (s *Zookeeper) Run() {
+	if port := viper.GetString("grpc_port"); port != "" {
+		go s.runGRPC(port)
+	}
 	err := s.gin.Run("0.0.0.0:" + viper.GetString("port"))
 	if err != nil {
 		log.Fatal(err.Error())
@@ -108,7 +156,7 @@ func (s *Zookeeper) GetFastestBroker() string {
 	var fastestBroker string
 	var latency time.Duration
 	for name, b := range s.brokers {
-		if !b.Health {
+		if !b.Health || b.Draining() {
 			continue
 		}
 		if latency == 0 || b.Latency < latency {
@@ -135,11 +183,6 @@ func (s *Zookeeper) GetSlowestBroker() string {
 }
 
 func (s *Zookeeper) ImportExport(source, target *broker.Client) {
-	source.Mutex.Lock()
-	target.Mutex.Lock()
-	defer source.Mutex.Unlock()
-	defer target.Mutex.Unlock()
-
 	log.WithFields(log.Fields{
 		"fastest_broker": target,
 		"slowest_broker": source,
@@ -149,29 +192,70 @@ func (s *Zookeeper) ImportExport(source, target *broker.Client) {
 		log.Errorf("No keys found in slowest broker")
 		return
 	}
+	if err := s.moveKey(key, isMaster, source, target); err != nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"broker": source.Name,
+	}).Info("ImportExport done successfully")
+}
+
+// moveKey copies the messages of the key from source to target, reassigns the key to
+// target and removes it from source. A master copy that can't be removed from source
+// is demoted so it no longer serves pops.
+func (s *Zookeeper) moveKey(key string, isMaster bool, source, target *broker.Client) error {
+	if err := s.transferKey(key, isMaster, source, target); err != nil {
+		return err
+	}
+	err := source.RemoveKey(key)
+	if err == nil {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"broker": source.Name,
+		"key":    key,
+	}).Warnf("Couldn't remove moved key: %s", err.Error())
+	if !isMaster {
+		return nil
+	}
+	if err := source.KeySetMaster(key, false); err != nil {
+		log.WithFields(log.Fields{
+			"broker": source.Name,
+			"key":    key,
+		}).Warnf("Couldn't demote moved key: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *Zookeeper) transferKey(key string, isMaster bool, source, target *broker.Client) error {
+	source.Mutex.Lock()
+	target.Mutex.Lock()
+	defer source.Mutex.Unlock()
+	defer target.Mutex.Unlock()
+
 	keyData, err := source.Export(key)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"broker": source.Name,
 			"key":    key,
 		}).Errorf("Couldn't export key: %s", err.Error())
-		return
+		return err
 	}
 
 	log.WithFields(log.Fields{
 		"broker":   target.Name,
 		"key":      key,
 		"isMaster": isMaster,
-		"keyData":  keyData.Values,
-	}).Info("Importing key to fastest broker")
+		"count":    len(keyData.Messages),
+	}).Info("Importing key to target broker")
 
-	err = target.Import(key, isMaster, keyData.Values)
+	err = target.Import(key, isMaster, keyData.Messages)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"broker": target.Name,
 			"key":    key,
 		}).Errorf("Couldn't import key: %s", err.Error())
-		return
+		return err
 	}
 
 	_, err = s.db.Exec("UPDATE queues SET broker = $1 WHERE broker = $2 AND queue = $3", target.Name, source.Name, key)
@@ -179,11 +263,9 @@ func (s *Zookeeper) ImportExport(source, target *broker.Client) {
 		log.WithFields(log.Fields{
 			"broker": source.Name,
 		}).Errorf("Couldn't update keys in database: %s", err.Error())
-		return
+		return err
 	}
-	log.WithFields(log.Fields{
-		"broker": source.Name,
-	}).Info("ImportExport done successfully")
+	return s.pinRoute(key)
 }
 
 func (s *Zookeeper) LoadBalancer() {
@@ -197,6 +279,9 @@ func (s *Zookeeper) LoadBalancer() {
 			log.WithFields(log.Fields{
 				"scale_factor": scaleFactor,
 			}).Info("Checking if scaling is needed...")
+			if s.rebalance() {
+				continue
+			}
 			fastestBroker := s.brokers[s.GetFastestBroker()]
 			slowestBroker := s.brokers[s.GetSlowestBroker()]
 			//fastestBroker := s.brokers["broker1"]
@@ -217,26 +302,26 @@ func (s *Zookeeper) LoadBalancer() {
 	}
 }
 
+// GetRandomKey returns a key held by slow and not by fast whose copies stay spread
+// across failure domains if the copy held by slow moves to fast, and whether slow is
+// its master
 func (s *Zookeeper) GetRandomKey(slow *broker.Client, fast *broker.Client) (string, bool) {
-	rows, err := s.db.Query("SELECT queue, is_master, broker FROM queues WHERE broker = $1 AND NOT EXISTS (SELECT * FROM queues q2 WHERE q2.broker = $2 AND q2.queue = queues.queue)", slow.Name, fast.Name)
+	keys, err := s.keyAssignments(anyKey)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"broker": slow.Name,
 		}).Errorf("Couldn't get keys assigned to the queue from database: %s", err.Error())
 		return "", false
 	}
-	for rows.Next() {
-		var key string
-		var isMaster bool
-		var brokerName string
-		err := rows.Scan(&key, &isMaster, &brokerName)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"broker": slow.Name,
-			}).Warnf("Couldn't scan row: %s", err.Error())
+	for _, k := range keys {
+		holders := append([]string{k.Master}, k.Replicas...)
+		if !slices.Contains(holders, slow.Name) || slices.Contains(holders, fast.Name) {
 			continue
 		}
-		return key, isMaster
+		if !s.keepsSpread(k, slow, fast) {
+			continue
+		}
+		return k.Key, k.Master == slow.Name
 	}
 	return "", false
 }
@@ -287,6 +372,10 @@ func (s *Zookeeper) RecoverFromFailure(b *broker.Client) error {
 	log.WithFields(log.Fields{
 		"broker": b.Name,
 	}).Info("Recovering from broker failure")
+	held, err := s.keyAssignments(anyKey)
+	if err != nil {
+		return err
+	}
 	rows, err := s.db.Query("SELECT * FROM queues WHERE broker = $1 AND is_master = True", b.Name)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -318,14 +407,20 @@ func (s *Zookeeper) RecoverFromFailure(b *broker.Client) error {
 			}).Warn("No replica brokers found for key")
 			return errors.New("no replica brokers found for key")
 		}
-		selectedReplica := replicas[0]
+		selectedReplica, err := s.promoteReplica(key, replicas)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key": key,
+			}).Warnf("Couldn't choose replica to promote: %s", err.Error())
+			return err
+		}
 
 		log.WithFields(log.Fields{
 			"key":    key,
 			"broker": selectedReplica.Name,
 		}).Info("Setting replica as master")
 
-		err = s.db.QueryRow("UPDATE queues SET is_master = True WHERE queue = $1 AND broker = $2 RETURNING queue", key, selectedReplica.Name).Scan(&key)
+		err = s.promoteInDatabase(key, b, selectedReplica)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"key":    key,
@@ -353,9 +448,45 @@ func (s *Zookeeper) RecoverFromFailure(b *broker.Client) error {
 		}).Warnf("Couldn't delete broker from database: %s", err.Error())
 		return err
 	}
+	for _, k := range held {
+		if k.Master != b.Name && !slices.Contains(k.Replicas, b.Name) {
+			continue
+		}
+		if err := s.pinRoute(k.Key); err != nil {
+			return err
+		}
+		if err := s.replaceCopy(k, b); err != nil {
+			log.WithFields(log.Fields{
+				"key":    k.Key,
+				"broker": b.Name,
+			}).Warnf("Couldn't replace lost copy of key: %s", err.Error())
+		}
+	}
 	return nil
 }
 
+// promoteInDatabase makes the replica the master of the key in place of the failed
+// broker. The failed master is demoted first since a key can't have two masters.
+func (s *Zookeeper) promoteInDatabase(key string, failed, replica *broker.Client) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE queues SET is_master = False WHERE queue = $1 AND broker = $2", key, failed.Name); err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE queues SET is_master = True WHERE queue = $1 AND broker = $2", key, replica.Name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
 func (s *Zookeeper) healthCheck(c *gin.Context) {
 	err := s.db.Ping()
 	if err == nil {
@@ -365,104 +496,428 @@ func (s *Zookeeper) healthCheck(c *gin.Context) {
 	c.JSON(http.StatusServiceUnavailable, gin.H{"message": err})
 }
 
-// Push pushes a message to the key
+// Push pushes a message to the key. Messages with a delay or a deliver_at time are
+// held back until they are due. A push repeating the idempotency key of a previous
+// push inside the deduplication window returns the original result instead.
 func (s *Zookeeper) Push(c *gin.Context) {
-	elem := &types.Element{}
-	if err := c.ShouldBindJSON(&elem); err != nil {
+	req := &types.PushRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
 		log.Debugf("Error binding request: %s", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(c, invalid(err))
 		return
 	}
 
-	if s.GetMasterBroker(elem.Key) == nil {
-		log.WithFields(log.Fields{
-			"key": elem.Key,
-		}).Infof("No master broker found for key. Assigning one...")
-		err := s.AssignKey(elem.Key)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"key": elem.Key,
-			}).Warnf("Couldn't assign key to a broker: %s", err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = c.GetHeader("Idempotency-Key")
+	}
+	result, err := s.push(req)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.PushResponse{ID: result.ID, Key: result.Key, DeliverAt: result.DeliverAt})
+}
+
+// push pushes or schedules the message of a push request. A request repeating the
+// idempotency key of a previous push inside the deduplication window returns the
+// original result instead. The blob of a message that isn't pushed is removed.
+func (s *Zookeeper) push(req *types.PushRequest) (*types.PushResult, error) {
+	var claim string
+	if req.IdempotencyKey != "" {
+		prev, c, err := s.beginIdempotentPush(req.IdempotencyKey)
+		if err != nil || prev != nil {
+			removeBlob(&types.Element{Key: req.Key, Blob: req.Blob})
+			return prev, err
 		}
+		claim = c
+	}
+
+	result := &types.PushResult{Key: req.Key, Status: types.StatusFailed}
+	if req.IdempotencyKey != "" {
+		defer s.finishIdempotentPush(req.IdempotencyKey, claim, result)
+	}
+
+	adm := newAdmission()
+	elem, err := s.prepareElement(req, adm)
+	if err != nil {
+		removeBlob(&types.Element{Key: req.Key, Blob: req.Blob})
+		return nil, err
+	}
+	if err := s.submitElement(elem); err != nil {
+		removeBlob(elem)
+		return nil, err
+	}
+	if !scheduled(elem) {
+		s.evictOldest(adm, elem)
+	}
+	*result = types.PushResult{ID: elem.ID, Key: elem.Key, Status: types.StatusOK, DeliverAt: elem.DeliverAt}
+	return result, nil
+}
+
+// pushElement pushes a message to the master and the replicas of its key, assigning
+// the key to brokers first if needed. The message gets the next sequence number of
+// its key.
+func (s *Zookeeper) pushElement(elem *types.Element) error {
+	if err := s.EnsureKeyAssigned(elem.Key); err != nil {
+		return err
+	}
+	if err := s.assignSeqs([]*types.Element{elem}); err != nil {
+		return err
 	}
 
+	// The master comes last in the brokers of a key but is pushed first, so replicas
+	// never hold a message their master misses
 	brokers := s.GetBrokers(elem.Key)
+	if len(brokers) > 0 {
+		brokers = append(brokers[len(brokers)-1:], brokers[:len(brokers)-1]...)
+	}
 	for _, b := range brokers {
 		log.WithFields(log.Fields{
 			"key":    elem.Key,
 			"broker": b.Name,
 		}).Info("Pushing message to broker")
-		err := b.Push(elem)
+		err := s.retryUnassigned(b, []string{elem.Key}, func() error {
+			return b.Push(elem)
+		})
 		if err != nil {
 			log.WithFields(log.Fields{
 				"key":    elem.Key,
 				"broker": b.Name,
 			}).Warnf("Couldn't push message to broker: %s", err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return err
 		}
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "ok"})
-	return
+	s.notifier.Notify(elem.Key)
+	return nil
 }
 
-// Pop pops a message
-func (s *Zookeeper) Pop(c *gin.Context) {
-	var empty = true
-	var res *types.Element
-	for _, b := range s.brokers {
-		if !b.Health {
-			continue
+// PushBatch pushes a list of messages. Messages are grouped by the brokers responsible
+// for their keys, so every broker receives one batched call for the keys it masters
+// and one for the keys it replicates. The response holds a status for each message in
+// the order they were sent.
+func (s *Zookeeper) PushBatch(c *gin.Context) {
+	req := &types.BatchPushRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Debugf("Error binding request: %s", err.Error())
+		fail(c, invalid(err))
+		return
+	}
+
+	results := s.pushBatch(req.Messages)
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Status != types.StatusOK {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+	respond(c, status, &types.BatchPushResponse{Results: results})
+}
+
+// pushBatch pushes a list of messages and returns the result of each of them
+func (s *Zookeeper) pushBatch(reqs []types.PushRequest) []types.PushResult {
+	results := make([]types.PushResult, len(reqs))
+	messages := make([]*types.Element, len(reqs))
+	keyBrokers := make(map[string][]*broker.Client)
+	masterBatches := make(map[string][]int)
+	replicaBatches := make(map[string][]int)
+	var pending []*types.Element
+	claims := make([]string, len(reqs))
+	adm := newAdmission()
+	for index := range reqs {
+		if key := reqs[index].IdempotencyKey; key != "" {
+			prev, claim, err := s.beginIdempotentPush(key)
+			if err != nil {
+				results[index] = types.PushResult{Key: reqs[index].Key, Status: types.StatusFailed, Error: err.Error()}
+				continue
+			}
+			if prev != nil {
+				results[index] = *prev
+				continue
+			}
+			claims[index] = claim
 		}
-		var err error
-		log.WithFields(log.Fields{
-			"broker": b.Name,
-		}).Info("Getting front value from broker")
 
-		res, err = b.Front()
+		elem, err := s.prepareElement(&reqs[index], adm)
 		if err != nil {
-			log.WithFields(log.Fields{
-				"broker": b.Name,
-			}).Warnf("Couldn't get front value: %s", err.Error())
+			results[index] = types.PushResult{Key: reqs[index].Key, Status: types.StatusFailed, Error: err.Error()}
 			continue
 		}
-		if res.Key == "" {
+		messages[index] = elem
+		results[index] = types.PushResult{ID: elem.ID, Key: elem.Key, Status: types.StatusOK, DeliverAt: elem.DeliverAt}
+		if scheduled(elem) {
+			if err := s.schedule(elem); err != nil {
+				results[index].Status = types.StatusFailed
+				results[index].Error = err.Error()
+			}
+			continue
+		}
+
+		brokers, ok := keyBrokers[elem.Key]
+		if !ok {
+			if err := s.EnsureKeyAssigned(elem.Key); err != nil {
+				results[index].Status = types.StatusFailed
+				results[index].Error = err.Error()
+				continue
+			}
+			brokers = s.GetBrokers(elem.Key)
+			keyBrokers[elem.Key] = brokers
+		}
+		if len(brokers) == 0 {
 			continue
 		}
+		master := brokers[len(brokers)-1]
+		masterBatches[master.Name] = append(masterBatches[master.Name], index)
+		pending = append(pending, elem)
+	}
+
+	// Masters get their messages first, so replicas only get the messages their
+	// master has
+	if len(pending) > 0 {
+		if err := s.assignSeqs(pending); err != nil {
+			for _, indices := range masterBatches {
+				for _, index := range indices {
+					results[index].Status = types.StatusFailed
+					results[index].Error = err.Error()
+				}
+			}
+			masterBatches = nil
+		}
+		for name, indices := range masterBatches {
+			if err := s.pushIndices(name, messages, indices); err != nil {
+				for _, index := range indices {
+					results[index].Status = types.StatusFailed
+					results[index].Error = err.Error()
+				}
+				continue
+			}
+			for _, index := range indices {
+				brokers := keyBrokers[messages[index].Key]
+				for _, b := range brokers[:len(brokers)-1] {
+					replicaBatches[b.Name] = append(replicaBatches[b.Name], index)
+				}
+			}
+		}
+	}
+
+	for name, indices := range replicaBatches {
+		if err := s.pushIndices(name, messages, indices); err != nil {
+			for _, index := range indices {
+				results[index].Status = types.StatusFailed
+				results[index].Error = err.Error()
+			}
+		}
+	}
+
+	for index, result := range results {
+		if claims[index] != "" {
+			s.finishIdempotentPush(reqs[index].IdempotencyKey, claims[index], &results[index])
+		}
+		if result.Status != types.StatusOK {
+			removeBlob(messages[index])
+		}
+		if result.Status == types.StatusOK && messages[index] != nil && !scheduled(messages[index]) {
+			s.evictOldest(adm, messages[index])
+			s.notifier.Notify(result.Key)
+		}
+	}
+	return results
+}
+
+// pushIndices pushes the messages at the indices to the broker in a single batch
+func (s *Zookeeper) pushIndices(name string, messages []*types.Element, indices []int) error {
+	b := s.brokers[name]
+	elems := make([]types.Element, 0, len(indices))
+	var keys []string
+	for _, index := range indices {
+		elems = append(elems, *messages[index])
+		keys = append(keys, messages[index].Key)
+	}
+
+	log.WithFields(log.Fields{
+		"broker": name,
+		"count":  len(elems),
+	}).Info("Pushing batch to broker")
+	err := s.retryUnassigned(b, keys, func() error {
+		return b.PushBatch(elems)
+	})
+	if err != nil {
 		log.WithFields(log.Fields{
-			"broker": b.Name,
-			"key":    res.Key,
-		}).Info("Got a message from broker")
-		s.Erase(res.Key)
-		empty = false
-		break
+			"broker": name,
+			"count":  len(elems),
+		}).Warnf("Couldn't push batch to broker: %s", err.Error())
 	}
+	return err
+}
 
-	if empty {
+// EnsureKeyAssigned assigns the key to brokers if it doesn't have a master yet. With
+// consistent hash routing every key has a master on the ring, so the key is only
+// looked up when that master is down, in which case a key that was never assigned is
+// assigned to healthy brokers instead.
+func (s *Zookeeper) EnsureKeyAssigned(key string) error {
+	master := s.GetMasterBroker(key)
+	if master != nil && (s.router == nil || master.Health) {
+		return nil
+	}
+	if master != nil {
+		assignments, err := s.keyAssignments(key)
+		if err != nil || len(assignments) > 0 {
+			return err
+		}
+	}
+	log.WithFields(log.Fields{
+		"key": key,
+	}).Infof("No master broker found for key. Assigning one...")
+	err := s.AssignKey(key)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Warnf("Couldn't assign key to a broker: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// Pop leases a message from any key, or from the keys matching the glob pattern in the
+// pattern query parameter, e.g. ?pattern=orders.*. The response holds the message ID
+// and the lease deadline; the message is redelivered unless it is acknowledged before
+// the deadline. With the wait query parameter, e.g. ?wait=20s, the request is held
+// until a message is pushed or the wait duration passes.
+func (s *Zookeeper) Pop(c *gin.Context) {
+	wait, err := parseWait(c.Query("wait"))
+	if err != nil {
+		fail(c, invalid(err))
+		return
+	}
+
+	pattern := c.Query("pattern")
+	res, err := s.popWait(c.Request.Context(), pattern, wait, s.popper(pattern))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if res == nil {
 		log.Info("Queue is empty")
-		c.JSON(200, gin.H{"message": "Queue is empty"})
+		respondDelivery(c, nil)
+		return
+	}
+	log.WithFields(log.Fields{
+		"key":   res.Element.Key,
+		"value": res.Element.Value,
+		"id":    res.ID,
+	}).Info("Popped message from key")
+	respondDelivery(c, res)
+}
+
+// PopKey leases a message from a specific key. The message is popped from the master
+// broker of the key and erased from its replicas once it is acknowledged. It accepts
+// the same wait query parameter as Pop. A key with wildcards is served like the
+// pattern parameter of Pop.
+func (s *Zookeeper) PopKey(c *gin.Context) {
+	key := c.Param("key")
+	wait, err := parseWait(c.Query("wait"))
+	if err != nil {
+		fail(c, invalid(err))
+		return
+	}
+
+	res, err := s.popWait(c.Request.Context(), key, wait, s.popper(key))
+	if err != nil {
+		fail(c, err)
 		return
 	}
+	if res == nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Info("Queue is empty")
+		respondDelivery(c, nil)
+		return
+	}
+
 	log.WithFields(log.Fields{
-		"key":   res.Key,
-		"value": res.Value,
+		"key":   res.Element.Key,
+		"value": res.Element.Value,
+		"id":    res.ID,
 	}).Info("Popped message from key")
-	c.JSON(200, gin.H{"message": "ok", "key": res.Key, "value": res.Value})
+	respondDelivery(c, res)
+}
+
+// PeekKey returns the front message of a specific key without removing it
+func (s *Zookeeper) PeekKey(c *gin.Context) {
+	key := c.Param("key")
+	master := s.GetMasterBroker(key)
+	if master == nil {
+		log.WithFields(log.Fields{
+			"key": key,
+		}).Info("No master broker found for key")
+		fail(c, errKeyNotFound)
+		return
+	}
+
+	res, err := master.Peek(key)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"broker": master.Name,
+			"key":    key,
+		}).Warnf("Couldn't peek message: %s", err.Error())
+		fail(c, err)
+		return
+	}
+	if res.Key == "" {
+		respond(c, http.StatusOK, &types.PeekResponse{})
+		return
+	}
+	if err := loadValue(res); err != nil {
+		fail(c, err)
+		return
+	}
+	respond(c, http.StatusOK, &types.PeekResponse{Message: res})
+}
+
+// newDelivery returns the API representation of a leased message
+func newDelivery(d *delivery) *types.Delivery {
+	return &types.Delivery{
+		ID:        d.ID,
+		Key:       d.Element.Key,
+		Value:     d.Element.Value,
+		Timestamp: d.Element.Timestamp,
+		Headers:   d.Element.Headers,
+		Priority:  d.Element.Priority,
+		Seq:       d.Element.Seq,
+		Deadline:  d.Deadline,
+		Attempts:  d.Attempts,
+	}
+}
+
+// parseWait parses the optional wait parameter of the pop routes
+func parseWait(wait string) (time.Duration, error) {
+	if wait == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(wait)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("wait must not be negative")
+	}
+	return d, nil
 }
 
-// Erase remove a message from queueName. It should be called after a message is popped from queueName
-func (s *Zookeeper) Erase(key string) {
+// EraseMessage removes the message with the given ID from every broker of its key. It
+// is called once a message popped from the master is acknowledged, so the replicas
+// drop that message even if acks arrive out of order.
+func (s *Zookeeper) EraseMessage(key, id string) {
 	log.WithFields(log.Fields{
 		"key": key,
-	}).Info("Erasing one message of a key replica brokers")
-	replicas := s.GetReplicaBrokers(key)
-	for _, b := range replicas {
-		err := b.Remove(key)
+		"id":  id,
+	}).Info("Erasing message from key brokers")
+	for _, b := range s.GetBrokers(key) {
+		err := b.RemoveMessage(key, id)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"key":    key,
+				"id":     id,
 				"broker": b.Name,
 			}).Warnf("Couldn't remove message from broker: %s", err.Error())
 		}
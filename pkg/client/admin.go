@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// KeyAssignment is the master and the replica brokers of a key
+type KeyAssignment struct {
+	Key      string   `json:"key"`
+	Master   string   `json:"master"`
+	Replicas []string `json:"replicas"`
+}
+
+// BrokerStatus is the health of a broker and the number of keys it holds
+type BrokerStatus struct {
+	Name      string            `json:"name"`
+	Address   string            `json:"address"`
+	Healthy   bool              `json:"healthy"`
+	Draining  bool              `json:"draining"`
+	Latency   string            `json:"latency"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Keys      int               `json:"keys"`
+	MasterFor int               `json:"master_for"`
+}
+
+// BrokerDistribution is the number of keys and the queued bytes of a broker next to
+// its target share of them, in proportion to its weight
+type BrokerDistribution struct {
+	Name        string  `json:"name"`
+	Weight      float64 `json:"weight"`
+	Keys        int     `json:"keys"`
+	TargetKeys  float64 `json:"target_keys"`
+	Bytes       int64   `json:"bytes"`
+	TargetBytes float64 `json:"target_bytes"`
+}
+
+// ListKeys returns the keys matching a glob pattern, or every key if it is empty, with
+// their master and replica brokers
+func (c *Client) ListKeys(ctx context.Context, pattern string) ([]KeyAssignment, error) {
+	res := struct {
+		Keys []KeyAssignment `json:"keys"`
+	}{}
+	path := "/admin/keys"
+	if pattern != "" {
+		path += "?pattern=" + url.QueryEscape(pattern)
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Keys, nil
+}
+
+// ListBrokers returns the status of every broker
+func (c *Client) ListBrokers(ctx context.Context) ([]BrokerStatus, error) {
+	res := struct {
+		Brokers []BrokerStatus `json:"brokers"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/admin/brokers", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Brokers, nil
+}
+
+// Distribution returns the load of every broker that can hold keys with its target
+// share of the load
+func (c *Client) Distribution(ctx context.Context) ([]BrokerDistribution, error) {
+	res := struct {
+		Brokers []BrokerDistribution `json:"brokers"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/admin/distribution", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Brokers, nil
+}
+
+// MoveKey moves the copy of a key held by the broker from to the broker to
+func (c *Client) MoveKey(ctx context.Context, key, from, to string) error {
+	req := map[string]string{"from": from, "to": to}
+	return c.do(ctx, http.MethodPost, "/admin/key/"+url.PathEscape(key)+"/move", req, nil)
+}
+
+// SetReplicationFactor sets the number of brokers holding a copy of a key, the master
+// included, and returns the brokers holding it afterwards
+func (c *Client) SetReplicationFactor(ctx context.Context, key string, factor int) (*KeyAssignment, error) {
+	res := &KeyAssignment{}
+	req := map[string]int{"factor": factor}
+	if err := c.do(ctx, http.MethodPut, "/admin/key/"+url.PathEscape(key)+"/replication", req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DrainBroker moves every key off a broker and stops assigning keys to it. It
+// returns the number of moved keys.
+func (c *Client) DrainBroker(ctx context.Context, name string) (int, error) {
+	res := struct {
+		Count int `json:"count"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "/admin/broker/"+url.PathEscape(name)+"/drain", nil, &res); err != nil {
+		return 0, err
+	}
+	return res.Count, nil
+}
+
+// UndrainBroker lets keys be assigned to a drained broker again
+func (c *Client) UndrainBroker(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/admin/broker/"+url.PathEscape(name)+"/drain", nil, nil)
+}
@@ -0,0 +1,380 @@
+// Package client is the Go client of the zookeeper HTTP API.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is the prefix of the routes of the zookeeper API used by the client
+const apiVersion = "/v1"
+
+// Interface is implemented by Client and by the in-memory Fake
+type Interface interface {
+	Push(ctx context.Context, req *PushRequest) (*PushResult, error)
+	PushBatch(ctx context.Context, reqs []PushRequest) ([]PushResult, error)
+	PushTransaction(ctx context.Context, reqs []PushRequest) (*TransactionResult, error)
+	Pop(ctx context.Context, opts PopOptions) (*Delivery, error)
+	Ack(ctx context.Context, id string) error
+	Nack(ctx context.Context, id string) (bool, error)
+	Subscribe(ctx context.Context, opts SubscribeOptions, handler func(d *Delivery) error) error
+}
+
+// Client is a client of a zookeeper. Failed requests are retried with exponential
+// backoff on network errors and on 409, 429 and 5xx responses.
+type Client struct {
+	Address    string
+	HTTPClient *http.Client
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+var _ Interface = (*Client)(nil)
+
+// New returns a client of the zookeeper at address, e.g. "http://localhost:8000"
+func New(address string) *Client {
+	return &Client{
+		Address:    strings.TrimSuffix(address, "/"),
+		HTTPClient: &http.Client{},
+		MaxRetries: 3,
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+	}
+}
+
+type pushRequest struct {
+	Key            string            `json:"key"`
+	Value          []byte            `json:"value"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	TTL            string            `json:"ttl,omitempty"`
+	Delay          string            `json:"delay,omitempty"`
+	DeliverAt      *time.Time        `json:"deliver_at,omitempty"`
+	Priority       int               `json:"priority,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+
+	ReplicationFactor int `json:"replication_factor,omitempty"`
+}
+
+func toPushRequest(req *PushRequest) pushRequest {
+	res := pushRequest{
+		Key:            req.Key,
+		Value:          req.Value,
+		Headers:        req.Headers,
+		DeliverAt:      req.DeliverAt,
+		Priority:       req.Priority,
+		IdempotencyKey: req.IdempotencyKey,
+
+		ReplicationFactor: req.ReplicationFactor,
+	}
+	if req.TTL > 0 {
+		res.TTL = req.TTL.String()
+	}
+	if req.Delay > 0 {
+		res.Delay = req.Delay.String()
+	}
+	// Retried pushes must not land twice, so every push carries an idempotency key
+	if res.IdempotencyKey == "" {
+		res.IdempotencyKey = newIdempotencyKey()
+	}
+	return res
+}
+
+type pushResponse struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key"`
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+}
+
+type popResponse struct {
+	Delivery *Delivery `json:"delivery"`
+}
+
+// Push pushes a message and returns its result
+func (c *Client) Push(ctx context.Context, req *PushRequest) (*PushResult, error) {
+	res := &pushResponse{}
+	err := c.do(ctx, http.MethodPost, "/push", toPushRequest(req), res)
+	if err != nil {
+		return nil, err
+	}
+	return &PushResult{ID: res.ID, Key: res.Key, Status: "ok", DeliverAt: res.DeliverAt}, nil
+}
+
+// PushBatch pushes several messages in one request and returns the result of each
+// of them. A partial failure is reported in the results, not as an error.
+func (c *Client) PushBatch(ctx context.Context, reqs []PushRequest) ([]PushResult, error) {
+	body := struct {
+		Messages []pushRequest `json:"messages"`
+	}{}
+	for i := range reqs {
+		body.Messages = append(body.Messages, toPushRequest(&reqs[i]))
+	}
+	res := struct {
+		Results []PushResult `json:"results"`
+	}{}
+	err := c.do(ctx, http.MethodPost, "/push/batch", body, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// PushTransaction pushes several messages, possibly to different keys, as one unit:
+// either every message is pushed or none is. An aborted transaction is retried, but
+// a transaction whose response was lost is not, since it may have been committed.
+func (c *Client) PushTransaction(ctx context.Context, reqs []PushRequest) (*TransactionResult, error) {
+	body := struct {
+		Messages []pushRequest `json:"messages"`
+	}{}
+	for i := range reqs {
+		msg := toPushRequest(&reqs[i])
+		msg.IdempotencyKey = reqs[i].IdempotencyKey
+		body.Messages = append(body.Messages, msg)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &TransactionResult{}
+	err = c.retry(ctx, func() (bool, error) {
+		again, err := c.attempt(ctx, http.MethodPost, "/push/transaction", data, res)
+		var apiErr *Error
+		return again && errors.As(err, &apiErr), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Pop leases a message. It returns nil if no message arrived within opts.Wait.
+func (c *Client) Pop(ctx context.Context, opts PopOptions) (*Delivery, error) {
+	path := "/pop"
+	query := url.Values{}
+	if opts.Key != "" {
+		path = "/key/" + url.PathEscape(opts.Key) + "/pop"
+	} else if opts.Pattern != "" {
+		query.Set("pattern", opts.Pattern)
+	}
+	if opts.Wait > 0 {
+		query.Set("wait", opts.Wait.String())
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	res := &popResponse{}
+	if err := c.do(ctx, http.MethodPost, path, nil, res); err != nil {
+		return nil, err
+	}
+	return res.Delivery, nil
+}
+
+// Ack acknowledges a delivery
+func (c *Client) Ack(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/ack/"+url.PathEscape(id), nil, nil)
+}
+
+// Nack releases a delivery for redelivery. It reports whether the message was moved
+// to the dead-letter key of its key instead.
+func (c *Client) Nack(ctx context.Context, id string) (bool, error) {
+	res := struct {
+		DeadLettered bool `json:"dead_lettered"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "/nack/"+url.PathEscape(id), nil, &res); err != nil {
+		return false, err
+	}
+	return res.DeadLettered, nil
+}
+
+// Subscribe streams deliveries to handler until the context is done, the stream is
+// closed or handler returns an error. Deliveries must be acknowledged to receive
+// more than opts.Prefetch of them.
+func (c *Client) Subscribe(ctx context.Context, opts SubscribeOptions, handler func(d *Delivery) error) error {
+	query := url.Values{}
+	if len(opts.Keys) > 0 {
+		query.Set("keys", strings.Join(opts.Keys, ","))
+	}
+	if opts.Prefetch > 0 {
+		query.Set("prefetch", strconv.Itoa(opts.Prefetch))
+	}
+
+	var resp *http.Response
+	err := c.retry(ctx, func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Address+apiVersion+"/subscribe?"+query.Encode(), nil)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err = c.HTTPClient.Do(req)
+		if err != nil {
+			return true, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return retryable(resp.StatusCode), readError(resp)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var event string
+	var data bytes.Buffer
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "" && data.Len() > 0:
+			if event == "message" {
+				d := &Delivery{}
+				if err := json.Unmarshal(data.Bytes(), d); err != nil {
+					return err
+				}
+				if err := handler(d); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data.Reset()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// GetKeySettings returns the retention settings of a key
+func (c *Client) GetKeySettings(ctx context.Context, key string) (*KeySettings, error) {
+	res := struct {
+		Settings KeySettings `json:"settings"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/admin/key/"+url.PathEscape(key)+"/settings", nil, &res); err != nil {
+		return nil, err
+	}
+	return &res.Settings, nil
+}
+
+// SetKeySettings sets the retention settings of a key
+func (c *Client) SetKeySettings(ctx context.Context, key string, settings *KeySettings) error {
+	return c.do(ctx, http.MethodPut, "/admin/key/"+url.PathEscape(key)+"/settings", settings, nil)
+}
+
+// do sends a JSON request and decodes the JSON response into resp, retrying on failure
+func (c *Client) do(ctx context.Context, method, path string, req interface{}, resp interface{}) error {
+	var body []byte
+	if req != nil {
+		var err error
+		body, err = json.Marshal(req)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.retry(ctx, func() (bool, error) {
+		return c.attempt(ctx, method, path, body, resp)
+	})
+}
+
+// attempt sends a request once. It reports whether the request may be retried if
+// it failed.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, resp interface{}) (bool, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, method, c.Address+apiVersion+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := c.HTTPClient.Do(httpRequest)
+	if err != nil {
+		return true, err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
+		return retryable(httpResponse.StatusCode), readError(httpResponse)
+	}
+	if resp == nil {
+		return false, nil
+	}
+	return false, json.NewDecoder(httpResponse.Body).Decode(resp)
+}
+
+// retry calls fn until it succeeds, fails with an error it doesn't want retried, the
+// retries are exhausted or the context is done
+func (c *Client) retry(ctx context.Context, fn func() (bool, error)) error {
+	for attempt := 0; ; attempt++ {
+		again, err := fn()
+		if err == nil || !again || attempt >= c.MaxRetries {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff returns the exponential backoff of an attempt with full jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	d := float64(c.MinBackoff) * math.Pow(2, float64(attempt))
+	if d > float64(c.MaxBackoff) {
+		d = float64(c.MaxBackoff)
+	}
+	return time.Duration(mathrand.Int63n(int64(d) + 1))
+}
+
+func readError(resp *http.Response) error {
+	data, _ := io.ReadAll(resp.Body)
+	res := struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(data, &res); err != nil || res.Error.Message == "" {
+		res.Error.Message = strings.TrimSpace(string(data))
+	}
+	return &Error{StatusCode: resp.StatusCode, Code: res.Error.Code, Message: res.Error.Message}
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
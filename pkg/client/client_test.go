@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of srv with short backoffs
+func newTestClient(srv *httptest.Server) *Client {
+	c := New(srv.URL)
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = 2 * time.Millisecond
+	return c
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"code":%q,"message":%q}}`, code, message)
+}
+
+func TestPushRetriesWithSameIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/push" {
+			t.Errorf("request to %s, want /v1/push", r.URL.Path)
+		}
+		body := pushRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("couldn't decode push: %s", err)
+		}
+		mu.Lock()
+		keys = append(keys, body.IdempotencyKey)
+		attempt := len(keys)
+		mu.Unlock()
+
+		switch attempt {
+		case 1:
+			writeError(w, http.StatusServiceUnavailable, "no_broker_available", "no broker available")
+		case 2:
+			writeError(w, http.StatusConflict, "push_in_progress", "a push with the same idempotency key is in progress")
+		default:
+			fmt.Fprintf(w, `{"id":"m1","key":%q}`, body.Key)
+		}
+	}))
+	defer srv.Close()
+
+	res, err := newTestClient(srv).Push(context.Background(), &PushRequest{Key: "orders", Value: []byte("1")})
+	if err != nil {
+		t.Fatalf("Push(): %s", err)
+	}
+	if res.ID != "m1" || res.Key != "orders" || !res.OK() {
+		t.Errorf("Push() = %+v", res)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("Push() sent %d requests, want 3", len(keys))
+	}
+	if keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("retries must repeat the idempotency key, got %v", keys)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		writeError(w, http.StatusInternalServerError, "internal_error", "boom")
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv)
+	c.MaxRetries = 2
+	err := c.Ack(context.Background(), "m1")
+	if !errors.Is(err, ErrServer) {
+		t.Errorf("Ack() = %v, want ErrServer", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Ack() sent %d requests, want 3", attempts)
+	}
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge} {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			writeError(w, status, "code", "message")
+		}))
+
+		err := newTestClient(srv).Ack(context.Background(), "m1")
+		srv.Close()
+		if err == nil {
+			t.Errorf("Ack() with status %d succeeded", status)
+		}
+		if attempts != 1 {
+			t.Errorf("Ack() with status %d sent %d requests, want 1", status, attempts)
+		}
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusServiceUnavailable, "no_broker_available", "no broker available")
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv)
+	c.MaxRetries = 1000
+	c.MinBackoff = time.Hour
+	c.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Ack(ctx, "m1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ack() = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{MinBackoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+	for attempt, limit := range []time.Duration{10, 20, 40, 80, 100, 100, 100} {
+		limit *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if d := c.backoff(attempt); d < 0 || d > limit {
+				t.Fatalf("backoff(%d) = %s, want between 0 and %s", attempt, d, limit)
+			}
+		}
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+		want   error
+	}{
+		{http.StatusBadRequest, "invalid_request", ErrBadRequest},
+		{http.StatusNotFound, "key_not_found", ErrNotFound},
+		{http.StatusNotFound, "lease_not_found", ErrNotFound},
+		{http.StatusConflict, "push_in_progress", ErrConflict},
+		{http.StatusTooManyRequests, "queue_full", ErrQueueFull},
+		{http.StatusRequestEntityTooLarge, "message_too_large", ErrTooLarge},
+		{http.StatusInternalServerError, "internal_error", ErrServer},
+		{http.StatusServiceUnavailable, "transaction_aborted", ErrTxAborted},
+		{http.StatusServiceUnavailable, "transaction_aborted", ErrServer},
+	}
+	sentinels := []error{ErrBadRequest, ErrNotFound, ErrConflict, ErrQueueFull, ErrTooLarge, ErrServer, ErrTxAborted}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, tt.status, tt.code, "message")
+		}))
+		c := newTestClient(srv)
+		c.MaxRetries = 0
+		err := c.Ack(context.Background(), "m1")
+		srv.Close()
+
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("status %d: got %v, want an *Error", tt.status, err)
+		}
+		if apiErr.StatusCode != tt.status || apiErr.Code != tt.code || apiErr.Message != "message" {
+			t.Errorf("status %d: got %+v", tt.status, apiErr)
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("status %d (%s): errors.Is(%v, %v) = false", tt.status, tt.code, err, tt.want)
+		}
+		for _, other := range sentinels {
+			if other == tt.want || (tt.code == "transaction_aborted" && (other == ErrServer || other == ErrTxAborted)) {
+				continue
+			}
+			if errors.Is(err, other) {
+				t.Errorf("status %d (%s): %v also matches %v", tt.status, tt.code, err, other)
+			}
+		}
+	}
+}
+
+func TestErrorWithoutEnvelope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv)
+	c.MaxRetries = 0
+	err := c.Ack(context.Background(), "m1")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Ack() = %v, want an *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusBadGateway || apiErr.Code != "" || apiErr.Message != "bad gateway" {
+		t.Errorf("Ack() = %+v", apiErr)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("keys"); got != "orders,payments.*" {
+			t.Errorf("keys = %q", got)
+		}
+		if got := r.URL.Query().Get("prefetch"); got != "5" {
+			t.Errorf("prefetch = %q", got)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event:message\ndata:{\"id\":\"m1\",\"key\":\"orders\",\"value\":\"MQ==\",\"attempts\":1}\n\n")
+		io.WriteString(w, ": keep-alive\n\n")
+		io.WriteString(w, "event:error\ndata:{\"error\":{\"code\":\"internal_error\"}}\n\n")
+		io.WriteString(w, "event:message\ndata:{\"id\":\"m2\",\"key\":\"payments.eu\",\"value\":\"Mg==\",\"attempts\":2}\n\n")
+	}))
+	defer srv.Close()
+
+	var got []string
+	err := newTestClient(srv).Subscribe(context.Background(), SubscribeOptions{Keys: []string{"orders", "payments.*"}, Prefetch: 5}, func(d *Delivery) error {
+		got = append(got, fmt.Sprintf("%s/%s/%s/%d", d.ID, d.Key, d.Value, d.Attempts))
+		return nil
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Subscribe() = %v, want io.ErrUnexpectedEOF once the stream ends", err)
+	}
+	want := []string{"m1/orders/1/1", "m2/payments.eu/2/2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscribe() delivered %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeStopsOnHandlerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "event:message\ndata:{\"id\":\"m%d\",\"key\":\"orders\"}\n\n", i)
+		}
+	}))
+	defer srv.Close()
+
+	stop := errors.New("stop")
+	calls := 0
+	err := newTestClient(srv).Subscribe(context.Background(), SubscribeOptions{}, func(d *Delivery) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Subscribe() = %v after %d calls, want the handler error after 1 call", err, calls)
+	}
+}
+
+func TestSubscribeRetriesConnection(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			writeError(w, http.StatusServiceUnavailable, "no_broker_available", "no broker available")
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			t.Errorf("Accept = %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event:message\ndata:{\"id\":\"m1\",\"key\":\"orders\"}\n\n")
+	}))
+	defer srv.Close()
+
+	calls := 0
+	newTestClient(srv).Subscribe(context.Background(), SubscribeOptions{}, func(d *Delivery) error {
+		calls++
+		return nil
+	})
+	if attempts != 2 || calls != 1 {
+		t.Errorf("Subscribe() connected %d times and delivered %d messages, want 2 and 1", attempts, calls)
+	}
+}
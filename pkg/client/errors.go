@@ -0,0 +1,64 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrBadRequest = errors.New("bad request")
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrQueueFull  = errors.New("queue is full")
+	ErrServer     = errors.New("server error")
+	ErrTxAborted  = errors.New("transaction aborted")
+	ErrTooLarge   = errors.New("message is too large")
+)
+
+// Error is an error returned by the zookeeper. Code is the error code of the API, e.g.
+// "key_not_found". It matches the sentinel error of its status code with errors.Is,
+// e.g. errors.Is(err, client.ErrNotFound).
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("zookeeper: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("zookeeper: %d %s (%s): %s", e.StatusCode, http.StatusText(e.StatusCode), e.Code, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrQueueFull:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	case ErrTxAborted:
+		return e.Code == "transaction_aborted"
+	case ErrTooLarge:
+		return e.StatusCode == http.StatusRequestEntityTooLarge
+	}
+	return false
+}
+
+// retryable reports whether a request failing with the status code may be retried
+func retryable(statusCode int) bool {
+	switch {
+	case statusCode == http.StatusConflict, statusCode == http.StatusTooManyRequests:
+		return true
+	case statusCode >= http.StatusInternalServerError:
+		return true
+	}
+	return false
+}
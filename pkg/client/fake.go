@@ -0,0 +1,337 @@
+package client
+
+import (
+	"Zookeeper/pkg/glob"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory implementation of Interface for tests. Messages are popped
+// by priority, then in push order. Leases expire after LeaseTimeout; the nth expired
+// or nacked delivery of a message is delivered again with Attempts set to n+1.
+type Fake struct {
+	LeaseTimeout time.Duration
+
+	mu       sync.Mutex
+	wake     chan struct{}
+	seq      int
+	queues   map[string][]*fakeMessage
+	leases   map[string]*fakeLease
+	attempts map[string]int
+	seqs     map[string]int64
+}
+
+type fakeMessage struct {
+	Message
+	seq int
+}
+
+type fakeLease struct {
+	msg      *fakeMessage
+	deadline time.Time
+}
+
+var _ Interface = (*Fake)(nil)
+
+// deadLetterSuffix is appended to a key by the zookeeper to name its dead-letter key
+const deadLetterSuffix = ".dlq"
+
+// NewFake returns an empty fake with a lease timeout of 30 seconds
+func NewFake() *Fake {
+	return &Fake{
+		LeaseTimeout: 30 * time.Second,
+		wake:         make(chan struct{}),
+		queues:       map[string][]*fakeMessage{},
+		leases:       map[string]*fakeLease{},
+		attempts:     map[string]int{},
+		seqs:         map[string]int64{},
+	}
+}
+
+// Push pushes a message
+func (f *Fake) Push(ctx context.Context, req *PushRequest) (*PushResult, error) {
+	if err := validatePush(req); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.push(req), nil
+}
+
+// validatePush rejects the push requests the zookeeper rejects
+func validatePush(req *PushRequest) *Error {
+	switch {
+	case req.Key == "":
+		return &Error{StatusCode: 400, Code: "invalid_request", Message: "key is required"}
+	case glob.IsPattern(req.Key):
+		return &Error{StatusCode: 400, Code: "invalid_request", Message: "key must not contain * or ?"}
+	case req.Priority < 0 || req.Priority > MaxPriority:
+		return &Error{StatusCode: 400, Code: "invalid_request", Message: fmt.Sprintf("priority must be between 0 and %d", MaxPriority)}
+	}
+	return nil
+}
+
+// push enqueues a valid message. The caller holds the lock.
+func (f *Fake) push(req *PushRequest) *PushResult {
+	f.seq++
+	f.seqs[req.Key]++
+	now := time.Now()
+	msg := &fakeMessage{
+		Message: Message{
+			ID:        fmt.Sprintf("fake-%d", f.seq),
+			Key:       req.Key,
+			Value:     req.Value,
+			Headers:   req.Headers,
+			Timestamp: now,
+			DeliverAt: req.DeliverAt,
+			Priority:  req.Priority,
+			Seq:       f.seqs[req.Key],
+		},
+		seq: f.seq,
+	}
+	if req.Delay > 0 {
+		deliverAt := now.Add(req.Delay)
+		msg.DeliverAt = &deliverAt
+	}
+	if req.TTL > 0 {
+		expiresAt := now.Add(req.TTL)
+		if msg.DeliverAt != nil {
+			expiresAt = msg.DeliverAt.Add(req.TTL)
+		}
+		msg.ExpiresAt = &expiresAt
+	}
+	f.enqueue(msg)
+	return &PushResult{ID: msg.ID, Key: msg.Key, Status: "ok", DeliverAt: msg.DeliverAt}
+}
+
+// PushBatch pushes several messages and returns the result of each of them
+func (f *Fake) PushBatch(ctx context.Context, reqs []PushRequest) ([]PushResult, error) {
+	results := make([]PushResult, 0, len(reqs))
+	for i := range reqs {
+		res, err := f.Push(ctx, &reqs[i])
+		if err != nil {
+			results = append(results, PushResult{Key: reqs[i].Key, Status: "failed", Error: err.Error()})
+			continue
+		}
+		results = append(results, *res)
+	}
+	return results, nil
+}
+
+// PushTransaction pushes several messages as one unit. Every message is validated
+// before any is pushed, and pops see either none or all of them.
+func (f *Fake) PushTransaction(ctx context.Context, reqs []PushRequest) (*TransactionResult, error) {
+	for i := range reqs {
+		if err := validatePush(&reqs[i]); err != nil {
+			return nil, &Error{StatusCode: 400, Code: "invalid_request", Message: fmt.Sprintf("message %d: %s", i, err.Message)}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	res := &TransactionResult{ID: fmt.Sprintf("fake-tx-%d", f.seq)}
+	for i := range reqs {
+		res.Results = append(res.Results, *f.push(&reqs[i]))
+	}
+	return res, nil
+}
+
+// Pop leases a message. It returns nil if no message arrived within opts.Wait.
+func (f *Fake) Pop(ctx context.Context, opts PopOptions) (*Delivery, error) {
+	var timeout <-chan time.Time
+	if opts.Wait > 0 {
+		timer := time.NewTimer(opts.Wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	key := opts.Key
+	if key == "" {
+		key = opts.Pattern
+	}
+	for {
+		f.mu.Lock()
+		d := f.pop(key)
+		wake := f.wake
+		f.mu.Unlock()
+		if d != nil || opts.Wait <= 0 {
+			return d, nil
+		}
+
+		select {
+		case <-wake:
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Ack acknowledges a delivery
+func (f *Fake) Ack(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, ok := f.leases[id]
+	if !ok || time.Now().After(l.deadline) {
+		return &Error{StatusCode: 404, Code: "lease_not_found", Message: "lease not found"}
+	}
+	delete(f.leases, id)
+	delete(f.attempts, id)
+	return nil
+}
+
+// Nack releases a delivery for immediate redelivery. The fake has no dead-letter
+// keys, so it never reports the message as dead-lettered.
+func (f *Fake) Nack(ctx context.Context, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, ok := f.leases[id]
+	if !ok || time.Now().After(l.deadline) {
+		return false, &Error{StatusCode: 404, Code: "lease_not_found", Message: "lease not found"}
+	}
+	delete(f.leases, id)
+	f.enqueue(l.msg)
+	return false, nil
+}
+
+// Subscribe passes deliveries to handler until the context is done or handler
+// returns an error. At most opts.Prefetch deliveries, 10 by default, are unacknowledged.
+func (f *Fake) Subscribe(ctx context.Context, opts SubscribeOptions, handler func(d *Delivery) error) error {
+	prefetch := opts.Prefetch
+	if prefetch <= 0 {
+		prefetch = 10
+	}
+	keys := opts.Keys
+	if len(keys) == 0 {
+		keys = []string{""}
+	}
+
+	var outstanding []string
+	next := 0
+	for {
+		f.mu.Lock()
+		outstanding = f.filterLeased(outstanding)
+		var d *Delivery
+		if len(outstanding) < prefetch {
+			for i := 0; i < len(keys) && d == nil; i++ {
+				d = f.pop(keys[(next+i)%len(keys)])
+			}
+			next++
+		}
+		wake := f.wake
+		f.mu.Unlock()
+
+		if d != nil {
+			outstanding = append(outstanding, d.ID)
+			if err := handler(d); err != nil {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case <-wake:
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Len returns the number of queued messages of the key, excluding leased ones
+func (f *Fake) Len(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.queues[key])
+}
+
+// enqueue inserts a message by priority, then push order, and wakes waiting pops
+func (f *Fake) enqueue(msg *fakeMessage) {
+	q := f.queues[msg.Key]
+	i := sort.Search(len(q), func(i int) bool {
+		if q[i].Priority != msg.Priority {
+			return q[i].Priority < msg.Priority
+		}
+		return q[i].seq > msg.seq
+	})
+	q = append(q, nil)
+	copy(q[i+1:], q[i:])
+	q[i] = msg
+	f.queues[msg.Key] = q
+
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
+
+// pop leases the first visible message of the key, of the keys matching it if it is a
+// pattern, or of any key if it is empty. Like the zookeeper, it only pops from
+// dead-letter keys when they are named. Expired leases are released first and expired
+// messages are dropped.
+func (f *Fake) pop(key string) *Delivery {
+	now := time.Now()
+	for id, l := range f.leases {
+		if now.After(l.deadline) {
+			delete(f.leases, id)
+			f.enqueue(l.msg)
+		}
+	}
+
+	keys := []string{key}
+	if key == "" || glob.IsPattern(key) {
+		keys = keys[:0]
+		for k := range f.queues {
+			if strings.HasSuffix(k, deadLetterSuffix) && !strings.HasSuffix(key, deadLetterSuffix) {
+				continue
+			}
+			if key == "" || glob.Match(key, k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+	}
+
+	for _, k := range keys {
+		q := f.queues[k]
+		for i := 0; i < len(q); i++ {
+			msg := q[i]
+			if msg.ExpiresAt != nil && now.After(*msg.ExpiresAt) {
+				q = append(q[:i], q[i+1:]...)
+				i--
+				continue
+			}
+			if msg.DeliverAt != nil && now.Before(*msg.DeliverAt) {
+				continue
+			}
+			f.queues[k] = append(q[:i], q[i+1:]...)
+
+			f.attempts[msg.ID]++
+			l := &fakeLease{msg: msg, deadline: now.Add(f.LeaseTimeout)}
+			f.leases[msg.ID] = l
+			return &Delivery{Message: msg.Message, Deadline: l.deadline, Attempts: f.attempts[msg.ID]}
+		}
+		f.queues[k] = q
+	}
+	return nil
+}
+
+// filterLeased returns the ids that are still leased
+func (f *Fake) filterLeased(ids []string) []string {
+	res := ids[:0]
+	for _, id := range ids {
+		if _, ok := f.leases[id]; ok {
+			res = append(res, id)
+		}
+	}
+	return res
+}
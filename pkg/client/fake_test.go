@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func popIDs(t *testing.T, f *Fake, opts PopOptions, n int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < n; i++ {
+		d, err := f.Pop(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("Pop(): %s", err)
+		}
+		if d == nil {
+			break
+		}
+		ids = append(ids, string(d.Value))
+	}
+	return ids
+}
+
+func push(t *testing.T, f *Fake, key, value string, priority int) *PushResult {
+	t.Helper()
+	res, err := f.Push(context.Background(), &PushRequest{Key: key, Value: []byte(value), Priority: priority})
+	if err != nil {
+		t.Fatalf("Push(%s): %s", value, err)
+	}
+	return res
+}
+
+func TestFakePriorityOrder(t *testing.T) {
+	f := NewFake()
+	push(t, f, "orders", "a", 0)
+	push(t, f, "orders", "b", 5)
+	push(t, f, "orders", "c", 0)
+	push(t, f, "orders", "d", 9)
+
+	got := popIDs(t, f, PopOptions{Key: "orders"}, 10)
+	want := []string{"d", "b", "a", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("popped %v, want %v", got, want)
+	}
+}
+
+func TestFakeSequenceNumbers(t *testing.T) {
+	f := NewFake()
+	for _, key := range []string{"orders", "payments", "orders"} {
+		push(t, f, key, key, 0)
+	}
+	var seqs []int64
+	for i := 0; i < 3; i++ {
+		d, _ := f.Pop(context.Background(), PopOptions{})
+		seqs = append(seqs, d.Seq)
+	}
+	if want := []int64{1, 2, 1}; !reflect.DeepEqual(seqs, want) {
+		t.Errorf("sequence numbers %v, want %v per key", seqs, want)
+	}
+}
+
+func TestFakeNackRedelivers(t *testing.T) {
+	f := NewFake()
+	ctx := context.Background()
+	push(t, f, "orders", "a", 0)
+	push(t, f, "orders", "b", 9)
+
+	d, _ := f.Pop(ctx, PopOptions{Key: "orders"})
+	if string(d.Value) != "b" || d.Attempts != 1 {
+		t.Fatalf("Pop() = %s after %d attempts", d.Value, d.Attempts)
+	}
+	if deadLettered, err := f.Nack(ctx, d.ID); err != nil || deadLettered {
+		t.Fatalf("Nack() = %v, %v", deadLettered, err)
+	}
+
+	// A redelivery keeps its priority, so it is served before lower priorities
+	again, _ := f.Pop(ctx, PopOptions{Key: "orders"})
+	if again.ID != d.ID || again.Attempts != 2 {
+		t.Errorf("Pop() after Nack() = %s after %d attempts, want %s after 2", again.ID, again.Attempts, d.ID)
+	}
+	if err := f.Ack(ctx, again.ID); err != nil {
+		t.Errorf("Ack(): %s", err)
+	}
+	if err := f.Ack(ctx, again.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Ack() = %v, want ErrNotFound", err)
+	}
+	if _, err := f.Nack(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Nack() of an unknown lease = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFakeLeaseExpiry(t *testing.T) {
+	f := NewFake()
+	f.LeaseTimeout = 10 * time.Millisecond
+	ctx := context.Background()
+	push(t, f, "orders", "a", 0)
+
+	d, _ := f.Pop(ctx, PopOptions{Key: "orders"})
+	if next, _ := f.Pop(ctx, PopOptions{Key: "orders"}); next != nil {
+		t.Fatalf("Pop() returned leased message %s", next.ID)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := f.Ack(ctx, d.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ack() of an expired lease = %v, want ErrNotFound", err)
+	}
+	again, _ := f.Pop(ctx, PopOptions{Key: "orders"})
+	if again == nil || again.ID != d.ID || again.Attempts != 2 {
+		t.Errorf("Pop() after the lease expired = %+v, want %s after 2 attempts", again, d.ID)
+	}
+}
+
+func TestFakeDelayAndTTL(t *testing.T) {
+	f := NewFake()
+	ctx := context.Background()
+	f.Push(ctx, &PushRequest{Key: "orders", Value: []byte("late"), Delay: 30 * time.Millisecond})
+	f.Push(ctx, &PushRequest{Key: "orders", Value: []byte("stale"), TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+
+	if d, _ := f.Pop(ctx, PopOptions{Key: "orders"}); d != nil {
+		t.Fatalf("Pop() = %s, want nothing before the delay passed", d.Value)
+	}
+	d, _ := f.Pop(ctx, PopOptions{Key: "orders", Wait: time.Second})
+	if d == nil || string(d.Value) != "late" {
+		t.Errorf("Pop() with wait = %+v, want the delayed message", d)
+	}
+	if f.Len("orders") != 0 {
+		t.Errorf("Len() = %d, want the expired message dropped", f.Len("orders"))
+	}
+}
+
+func TestFakePatterns(t *testing.T) {
+	f := NewFake()
+	push(t, f, "orders.eu", "eu", 0)
+	push(t, f, "orders.dlq", "dead", 0)
+	push(t, f, "payments", "pay", 0)
+
+	if got := popIDs(t, f, PopOptions{Pattern: "orders.*"}, 10); !reflect.DeepEqual(got, []string{"eu"}) {
+		t.Errorf("Pop(orders.*) = %v, want dead-letter keys left out", got)
+	}
+	if got := popIDs(t, f, PopOptions{}, 10); !reflect.DeepEqual(got, []string{"pay"}) {
+		t.Errorf("Pop() from any key = %v, want dead-letter keys left out", got)
+	}
+	if got := popIDs(t, f, PopOptions{Pattern: "*.dlq"}, 10); !reflect.DeepEqual(got, []string{"dead"}) {
+		t.Errorf("Pop(*.dlq) = %v, want the dead-letter key", got)
+	}
+}
+
+func TestFakeValidation(t *testing.T) {
+	f := NewFake()
+	ctx := context.Background()
+	for _, req := range []PushRequest{
+		{Key: ""},
+		{Key: "orders.*"},
+		{Key: "orders?"},
+		{Key: "orders", Priority: -1},
+		{Key: "orders", Priority: MaxPriority + 1},
+	} {
+		if _, err := f.Push(ctx, &req); !errors.Is(err, ErrBadRequest) {
+			t.Errorf("Push(%+v) = %v, want ErrBadRequest", req, err)
+		}
+	}
+
+	results, err := f.PushBatch(ctx, []PushRequest{{Key: "orders"}, {Key: "orders.*"}})
+	if err != nil || len(results) != 2 || !results[0].OK() || results[1].OK() {
+		t.Errorf("PushBatch() = %+v, %v, want the second message to fail alone", results, err)
+	}
+	if _, err := f.PushTransaction(ctx, []PushRequest{{Key: "orders"}, {Key: ""}}); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("PushTransaction() = %v, want ErrBadRequest", err)
+	}
+	if f.Len("orders") != 1 {
+		t.Errorf("Len() = %d, want a rejected transaction to push nothing", f.Len("orders"))
+	}
+}
+
+func TestFakeSubscribePrefetch(t *testing.T) {
+	f := NewFake()
+	for _, value := range []string{"a", "b", "c"} {
+		push(t, f, "orders", value, 0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var got []string
+	err := f.Subscribe(ctx, SubscribeOptions{Keys: []string{"orders"}, Prefetch: 2}, func(d *Delivery) error {
+		got = append(got, string(d.Value))
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Subscribe() = %v, want context.DeadlineExceeded", err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscribe() delivered %v, want %v without acks", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rawContentType is the content type of raw message values
+const rawContentType = "application/octet-stream"
+
+// headerPrefix prefixes the message headers sent as HTTP headers by the raw routes
+const headerPrefix = "X-Message-Header-"
+
+// RawDelivery is a leased message whose value is streamed from Body instead of
+// being held in Value. Body must be closed.
+type RawDelivery struct {
+	Delivery
+	Body io.ReadCloser
+}
+
+// PushRaw pushes the content of body as the value of a message of req.Key, without
+// encoding it. req.Value is ignored. Since body can only be read once the push is
+// not retried.
+func (c *Client) PushRaw(ctx context.Context, req *PushRequest, body io.Reader) (*PushResult, error) {
+	path := c.Address + apiVersion + "/key/" + url.PathEscape(req.Key) + "/push"
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", rawContentType)
+	if req.TTL > 0 {
+		httpRequest.Header.Set("X-Message-TTL", req.TTL.String())
+	}
+	if req.Delay > 0 {
+		httpRequest.Header.Set("X-Message-Delay", req.Delay.String())
+	}
+	if req.DeliverAt != nil {
+		httpRequest.Header.Set("X-Message-Deliver-At", req.DeliverAt.Format(time.RFC3339))
+	}
+	if req.Priority != 0 {
+		httpRequest.Header.Set("X-Message-Priority", strconv.Itoa(req.Priority))
+	}
+	if req.IdempotencyKey != "" {
+		httpRequest.Header.Set("Idempotency-Key", req.IdempotencyKey)
+	}
+	for name, value := range req.Headers {
+		httpRequest.Header.Set(headerPrefix+name, value)
+	}
+
+	httpResponse, err := c.HTTPClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, readError(httpResponse)
+	}
+
+	res := &pushResponse{}
+	if err := json.NewDecoder(httpResponse.Body).Decode(res); err != nil {
+		return nil, err
+	}
+	return &PushResult{ID: res.ID, Key: res.Key, Status: "ok", DeliverAt: res.DeliverAt}, nil
+}
+
+// PopRaw leases a message like Pop and streams its value. It returns nil if no
+// message arrived within opts.Wait.
+func (c *Client) PopRaw(ctx context.Context, opts PopOptions) (*RawDelivery, error) {
+	path := "/pop"
+	query := url.Values{}
+	if opts.Key != "" {
+		path = "/key/" + url.PathEscape(opts.Key) + "/pop"
+	} else if opts.Pattern != "" {
+		query.Set("pattern", opts.Pattern)
+	}
+	if opts.Wait > 0 {
+		query.Set("wait", opts.Wait.String())
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Address+apiVersion+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Accept", rawContentType)
+	httpResponse, err := c.HTTPClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch httpResponse.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		httpResponse.Body.Close()
+		return nil, nil
+	default:
+		defer httpResponse.Body.Close()
+		return nil, readError(httpResponse)
+	}
+
+	header := httpResponse.Header
+	d := &RawDelivery{Body: httpResponse.Body}
+	d.ID = header.Get("X-Message-ID")
+	d.Key = header.Get("X-Message-Key")
+	d.Priority, _ = strconv.Atoi(header.Get("X-Message-Priority"))
+	d.Attempts, _ = strconv.Atoi(header.Get("X-Message-Attempts"))
+	d.Seq, _ = strconv.ParseInt(header.Get("X-Message-Seq"), 10, 64)
+	d.Timestamp, _ = time.Parse(time.RFC3339Nano, header.Get("X-Message-Timestamp"))
+	d.Deadline, _ = time.Parse(time.RFC3339Nano, header.Get("X-Lease-Deadline"))
+	for name, values := range header {
+		if !strings.HasPrefix(name, headerPrefix) || len(values) == 0 {
+			continue
+		}
+		if d.Headers == nil {
+			d.Headers = make(map[string]string)
+		}
+		d.Headers[strings.ToLower(strings.TrimPrefix(name, headerPrefix))] = values[0]
+	}
+	return d, nil
+}
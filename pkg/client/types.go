@@ -0,0 +1,101 @@
+package client
+
+import "time"
+
+// Overflow policies of a key that reached its maximum length
+const (
+	OverflowReject     = "reject"
+	OverflowDropOldest = "drop_oldest"
+	OverflowRoute      = "route"
+)
+
+// MaxPriority is the highest priority of a message
+const MaxPriority = 9
+
+// Message is a message of a key. Seq is its position in the key: consumers see
+// increasing sequence numbers per key, so a repeated or skipped number reveals a
+// duplicate or a gap.
+type Message struct {
+	ID        string            `json:"id"`
+	Key       string            `json:"key"`
+	Value     []byte            `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	DeliverAt *time.Time        `json:"deliver_at,omitempty"`
+	Priority  int               `json:"priority"`
+	Seq       int64             `json:"seq"`
+}
+
+// PushRequest is a message to push. TTL and Delay are optional, as is DeliverAt,
+// which can't be combined with Delay.
+type PushRequest struct {
+	Key            string            `json:"key"`
+	Value          []byte            `json:"value"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	TTL            time.Duration     `json:"-"`
+	Delay          time.Duration     `json:"-"`
+	DeliverAt      *time.Time        `json:"deliver_at,omitempty"`
+	Priority       int               `json:"priority,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+
+	// ReplicationFactor sets the number of brokers holding a copy of the key if the
+	// push creates it
+	ReplicationFactor int `json:"replication_factor,omitempty"`
+}
+
+// PushResult is the result of a pushed message
+type PushResult struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+}
+
+// OK reports whether the message was pushed
+func (r *PushResult) OK() bool {
+	return r.Status == "ok"
+}
+
+// TransactionResult is the result of a committed transaction
+type TransactionResult struct {
+	ID      string       `json:"id"`
+	Results []PushResult `json:"results"`
+}
+
+// Delivery is a message leased to a consumer. It must be acknowledged with Ack
+// before Deadline or it is delivered again.
+type Delivery struct {
+	Message
+	Deadline time.Time `json:"deadline"`
+	Attempts int       `json:"attempts"`
+}
+
+// PopOptions selects the key to pop from and how long to wait for a message. Pattern
+// is a glob pattern such as "orders.*" used when Key is empty, in which * matches any
+// sequence of characters and ? any single character. Without either, Pop pops from
+// any key.
+type PopOptions struct {
+	Key     string
+	Pattern string
+	Wait    time.Duration
+}
+
+// SubscribeOptions selects the keys or glob patterns to subscribe to, or any key if
+// Keys is empty.
+// Prefetch is the number of deliveries that may be unacknowledged at once; the
+// server default is used if it is zero.
+type SubscribeOptions struct {
+	Keys     []string
+	Prefetch int
+}
+
+// KeySettings are the retention settings of a key
+type KeySettings struct {
+	DefaultTTL     string `json:"default_ttl"`
+	MaxLength      int    `json:"max_length"`
+	OverflowPolicy string `json:"overflow_policy"`
+	OverflowKey    string `json:"overflow_key,omitempty"`
+	Weight         int    `json:"weight,omitempty"`
+}
@@ -0,0 +1,38 @@
+// Package glob matches keys against the glob patterns accepted by pops, subscriptions
+// and key listings, in which * matches any sequence of characters and ? any single
+// character.
+package glob
+
+import "strings"
+
+// IsPattern reports whether a key argument contains wildcards
+func IsPattern(key string) bool {
+	return strings.ContainsAny(key, "*?")
+}
+
+// Match reports whether the key matches the glob pattern
+func Match(pattern, key string) bool {
+	p, k := []rune(pattern), []rune(key)
+	star, mark := -1, 0
+	i, j := 0, 0
+	for j < len(k) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == k[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case star >= 0:
+			i = star + 1
+			mark++
+			j = mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
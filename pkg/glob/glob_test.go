@@ -0,0 +1,68 @@
+package glob
+
+import "testing"
+
+func TestIsPattern(t *testing.T) {
+	tests := map[string]bool{
+		"":          false,
+		"orders":    false,
+		"orders.*":  true,
+		"order?":    true,
+		"100%":      false,
+		"a_b":       false,
+		`orders\eu`: false,
+	}
+	for key, want := range tests {
+		if got := IsPattern(key); got != want {
+			t.Errorf("IsPattern(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "orders", false},
+		{"*", "", true},
+		{"*", "orders", true},
+		{"**", "orders", true},
+		{"?", "", false},
+		{"?", "a", true},
+		{"?", "ab", false},
+		{"order?", "orders", true},
+		{"order?", "order", false},
+		{"orders", "orders", true},
+		{"orders", "orders.eu", false},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "orders.", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.dlq", true},
+		{"orders.*", "payments.eu", false},
+		{"*.dlq", "orders.dlq", true},
+		{"*.dlq", "orders.dlq.eu", false},
+		{"*.eu.*", "orders.eu.1", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"a*c", "abcbc", true},
+		{"a?c*", "abcdef", true},
+		// Only * and ? are wildcards
+		{"100%", "100%", true},
+		{"100%", "1000", false},
+		{"a_b", "a_b", true},
+		{"a_b", "aXb", false},
+		{`a\b`, `a\b`, true},
+		{`a\*`, `a\xyz`, true},
+		{`a\*`, "a*", false},
+		{"ü?", "üß", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}